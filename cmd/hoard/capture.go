@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/thijzert/doc-hoarder/internal/capture"
+	"github.com/thijzert/doc-hoarder/internal/fetch"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// captureURLs captures web pages from the command line. A URL of "-" reads
+// a list of URLs from standard input, one per line.
+func captureURLs(ctx context.Context, docStore storage.DocStore, fetcher *fetch.Fetcher, urls []string, opts capture.Options) error {
+	failed := 0
+	captureURL := func(u string) {
+		id, res, err := capture.URL(ctx, docStore, u, fetcher.Fetch, opts)
+		if err != nil {
+			log.Printf("error capturing %s: %v", u, err)
+			failed++
+			return
+		}
+		for _, m := range res.Missing {
+			log.Printf("%s: could not attach %s", u, m)
+		}
+		log.Printf("Captured %s as g%s", u, id)
+	}
+
+	for _, u := range urls {
+		if u != "-" {
+			captureURL(u)
+			continue
+		}
+
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && line[0] != '#' {
+				captureURL(line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		log.Printf("%d captures failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+	"github.com/thijzert/doc-hoarder/internal/warc"
+)
+
+// exportWARC writes documents to WARC files. If the output location is a
+// directory, a separate file is written for each document; otherwise all
+// documents end up in the same file. If no document IDs are specified, the
+// whole store is exported.
+func exportWARC(ctx context.Context, docStore storage.DocStore, output string, docids []string) error {
+	for i, id := range docids {
+		docids[i] = strings.TrimPrefix(id, "g")
+	}
+
+	if fi, err := os.Stat(output); err == nil && fi.IsDir() {
+		if len(docids) == 0 {
+			ids, err := docStore.DocumentIDs(ctx)
+			if err != nil {
+				return err
+			}
+			docids = ids
+		}
+		for _, id := range docids {
+			err := writeWARCFile(path.Join(output, "g"+id+".warc.gz"), func(w *warc.Writer) error {
+				return warc.ExportDocument(ctx, w, docStore, id)
+			})
+			if err != nil {
+				return fmt.Errorf("error exporting document %s: %w", id, err)
+			}
+		}
+		return nil
+	}
+
+	return writeWARCFile(output, func(w *warc.Writer) error {
+		if len(docids) == 0 {
+			return warc.ExportStore(ctx, w, docStore)
+		}
+		for _, id := range docids {
+			if err := warc.ExportDocument(ctx, w, docStore, id); err != nil {
+				return fmt.Errorf("error exporting document %s: %w", id, err)
+			}
+		}
+		return nil
+	})
+}
+
+func writeWARCFile(filename string, contents func(*warc.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	w := warc.NewWriter(f, strings.HasSuffix(filename, ".gz"))
+	_, err = w.WriteWarcinfo(path.Base(filename), warc.DefaultWarcinfo("doc-hoarder/"+Version))
+	if err == nil {
+		err = contents(w)
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(filename)
+		return err
+	}
+	return f.Close()
+}
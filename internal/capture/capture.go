@@ -0,0 +1,448 @@
+// Package capture turns a web page and its subresources into a document. It
+// does server-side what the browser extension does client-side: scripts are
+// removed, and stylesheets, images and fonts are stored as attachments.
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/doctext"
+	"github.com/thijzert/doc-hoarder/internal/fetch"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A FetchFunc retrieves a subresource of a page
+type FetchFunc func(ctx context.Context, u *url.URL) (contentType string, body io.ReadCloser, err error)
+
+// ErrUnsupportedType is returned if a subresource cannot be stored as an attachment
+var ErrUnsupportedType = errors.New("unsupported subresource type")
+
+// ErrTooLarge is returned if a stylesheet is too large to be rewritten
+var ErrTooLarge = errors.New("stylesheet too large")
+
+// maxStylesheetSize limits the size of stylesheets, which are read into memory in order to rewrite them
+const maxStylesheetSize int64 = 16 << 20
+
+// Result describes a captured page
+type Result struct {
+	Title  string
+	IconID string
+
+	// Missing lists all subresources that could not be attached
+	Missing []string
+
+	// Attachments lists where each attachment came from. Their Source is
+	// left for the caller to fill in.
+	Attachments []storage.AttachmentOrigin
+}
+
+type page struct {
+	trns  storage.DocTransaction
+	fetch FetchFunc
+
+	attached map[string]string
+	result   Result
+}
+
+// Page rewrites a HTML page, attaches all subresources it references, and
+// writes the result as the transaction's document.bin.
+func Page(ctx context.Context, trns storage.DocTransaction, pageURL *url.URL, r io.Reader, fetch FetchFunc) (Result, error) {
+	p := &page{
+		trns:     trns,
+		fetch:    fetch,
+		attached: make(map[string]string),
+	}
+
+	doc, err := html.Parse(r)
+	if err != nil {
+		return p.result, err
+	}
+
+	base := pageURL
+	if b := findBase(doc); b != "" {
+		if u, err := pageURL.Parse(b); err == nil {
+			base = u
+		}
+	}
+
+	if err := p.rewriteNode(ctx, doc, base); err != nil {
+		return p.result, err
+	}
+
+	if p.result.IconID == "" {
+		if u, err := pageURL.Parse("/favicon.ico"); err == nil {
+			if name, err := p.attach(ctx, u); err == nil {
+				p.result.IconID = strings.TrimSuffix(name, path.Ext(name))
+			}
+		}
+	}
+
+	g, err := trns.WriteRootFile(ctx, "document.bin")
+	if err != nil {
+		return p.result, err
+	}
+	err = html.Render(g, doc)
+	if err != nil {
+		g.Close()
+		return p.result, err
+	}
+	return p.result, g.Close()
+}
+
+// Text returns the visible text a HTML page will have once it is captured
+func Text(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", err
+	}
+	removeElements(doc)
+
+	var b bytes.Buffer
+	err = html.Render(&b, doc)
+	if err != nil {
+		return "", err
+	}
+	return doctext.Extract(&b)
+}
+
+func removeElements(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if isRemoved(c) {
+			n.RemoveChild(c)
+		} else {
+			removeElements(c)
+		}
+		c = next
+	}
+}
+
+func findBase(n *html.Node) string {
+	if n.Type == html.ElementNode && n.DataAtom == atom.Base {
+		return getAttr(n, "href")
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if b := findBase(c); b != "" {
+			return b
+		}
+	}
+	return ""
+}
+
+func (p *page) rewriteNode(ctx context.Context, n *html.Node, base *url.URL) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if n.Type == html.ElementNode {
+		p.rewriteElement(ctx, n, base)
+	}
+
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if isRemoved(c) {
+			n.RemoveChild(c)
+		} else if err := p.rewriteNode(ctx, c, base); err != nil {
+			return err
+		}
+		c = next
+	}
+	return nil
+}
+
+// isRemoved determines if an element should be left out of the captured document
+func isRemoved(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	switch n.DataAtom {
+	case atom.Script, atom.Noscript, atom.Object, atom.Iframe, atom.Base:
+		return true
+	case atom.Link:
+		switch strings.ToLower(getAttr(n, "rel")) {
+		case "dns-prefetch", "preconnect", "preload", "prefetch", "modulepreload", "amphtml":
+			return true
+		}
+	}
+	return false
+}
+
+func (p *page) rewriteElement(ctx context.Context, n *html.Node, base *url.URL) {
+	// Remove scripting attributes (onclick, onload, onerror, etc)
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Namespace == "" && (strings.HasPrefix(a.Key, "on") || a.Key == "data-ga") {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+
+	if style := getAttr(n, "style"); style != "" {
+		setAttr(n, "style", p.rewriteCSS(ctx, style, base, "att/"))
+	}
+
+	switch n.DataAtom {
+	case atom.Img, atom.Source:
+		// Disable lazy loading
+		for _, k := range []string{"src", "srcset"} {
+			if v := getAttr(n, "data-"+k); v != "" {
+				setAttr(n, k, v)
+				removeAttr(n, "data-"+k)
+			}
+		}
+		p.attachAttr(ctx, n, "src", base)
+		if srcset := getAttr(n, "srcset"); srcset != "" {
+			setAttr(n, "srcset", p.rewriteSrcset(ctx, srcset, base))
+		}
+
+	case atom.Video:
+		p.attachAttr(ctx, n, "poster", base)
+
+	case atom.Input:
+		if strings.ToLower(getAttr(n, "type")) == "image" {
+			p.attachAttr(ctx, n, "src", base)
+		}
+
+	case atom.Link:
+		rel := strings.ToLower(getAttr(n, "rel"))
+		if rel == "stylesheet" {
+			if !p.attachAttr(ctx, n, "href", base) {
+				setAttr(n, "rel", "defunct-stylesheet")
+			}
+		} else if rel == "icon" || rel == "shortcut icon" || rel == "apple-touch-icon" {
+			if p.attachAttr(ctx, n, "href", base) {
+				if p.result.IconID == "" || rel == "apple-touch-icon" {
+					name := path.Base(getAttr(n, "href"))
+					p.result.IconID = strings.TrimSuffix(name, path.Ext(name))
+				}
+			}
+		}
+
+	case atom.Style:
+		if c := n.FirstChild; c != nil && c.Type == html.TextNode {
+			c.Data = p.rewriteCSS(ctx, c.Data, base, "att/")
+		}
+
+	case atom.Meta:
+		name := strings.ToLower(getAttr(n, "name"))
+		prop := strings.ToLower(getAttr(n, "property"))
+		if strings.HasSuffix(name, ":image") || strings.HasSuffix(prop, ":image") || strings.HasPrefix(name, "msapplication-") {
+			p.attachAttr(ctx, n, "content", base)
+		}
+
+	case atom.Title:
+		if p.result.Title == "" && n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+			p.result.Title = strings.TrimSpace(n.FirstChild.Data)
+		}
+
+	case atom.A, atom.Area, atom.Form:
+		// Keep links pointing to the original site
+		k := "href"
+		if n.DataAtom == atom.Form {
+			k = "action"
+		}
+		if v := getAttr(n, k); v != "" && !strings.HasPrefix(v, "#") {
+			if u, err := base.Parse(strings.TrimSpace(v)); err == nil {
+				setAttr(n, k, u.String())
+			}
+		}
+	}
+}
+
+// attachAttr replaces the URL in an attribute with a reference to an
+// attachment. It returns true if the attachment succeeded.
+func (p *page) attachAttr(ctx context.Context, n *html.Node, key string, base *url.URL) bool {
+	v := strings.TrimSpace(getAttr(n, key))
+	if v == "" {
+		return false
+	}
+	s, ok := p.attachRef(ctx, v, base, "att/")
+	if ok {
+		setAttr(n, key, s)
+	}
+	return ok
+}
+
+func (p *page) rewriteSrcset(ctx context.Context, srcset string, base *url.URL) string {
+	parts := strings.Split(srcset, ",")
+	for i, part := range parts {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if s, ok := p.attachRef(ctx, fields[0], base, "att/"); ok {
+			fields[0] = s
+		}
+		parts[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// attachRef attaches the subresource at a (relative) reference, and returns
+// the attachment's filename with the specified prefix
+func (p *page) attachRef(ctx context.Context, ref string, base *url.URL, prefix string) (string, bool) {
+	if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+		return "", false
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	name, err := p.attach(ctx, u)
+	if err != nil {
+		p.result.Missing = append(p.result.Missing, u.String())
+		return "", false
+	}
+	return prefix + name, true
+}
+
+// attach stores a subresource as an attachment, and returns its filename
+func (p *page) attach(ctx context.Context, u *url.URL) (string, error) {
+	u.Fragment = ""
+	key := u.String()
+	if name, ok := p.attached[key]; ok {
+		if name == "" {
+			return "", ErrUnsupportedType
+		}
+		return name, nil
+	}
+	p.attached[key] = ""
+
+	contentType, body, err := p.fetch(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	ext, ok := attachmentExtension(contentType, u.Path)
+	if !ok {
+		return "", ErrUnsupportedType
+	}
+
+	// Stylesheets are read into memory in order to rewrite them. Rather than
+	// truncating one that is too large, leave it out.
+	var css []byte
+	if ext == "css" {
+		css, err = io.ReadAll(io.LimitReader(body, maxStylesheetSize+1))
+		if err != nil {
+			return "", err
+		} else if int64(len(css)) > maxStylesheetSize {
+			return "", ErrTooLarge
+		}
+	}
+
+	attid, err := p.trns.NewAttachmentID(ctx, ext)
+	if err != nil {
+		return "", err
+	}
+	name := "t" + attid + "." + ext
+	p.attached[key] = name
+
+	var contents io.Reader = body
+	if ext == "css" {
+		// References inside an attached stylesheet are relative to the att/ directory
+		contents = strings.NewReader(p.rewriteCSS(ctx, string(css), u, ""))
+	}
+
+	g, err := p.trns.WriteAttachment(ctx, name)
+	if err == nil {
+		_, err = io.Copy(g, contents)
+		if cerr := g.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		// Don't leave the reserved attachment behind
+		p.trns.DeleteAttachment(ctx, name)
+		p.attached[key] = ""
+		return "", err
+	}
+
+	ct, _, _ := mime.ParseMediaType(contentType)
+	if e, ok := storage.AttachmentExtension(ct); !ok || e != ext {
+		// The extension was inferred from the URL
+		ct = ""
+	}
+	fetched := time.Now()
+	origin := storage.AttachmentOrigin{
+		Name:    name,
+		URL:     key,
+		Fetched: &fetched,
+	}
+	if resp, ok := body.(*fetch.Response); ok {
+		resp.Close()
+		origin = resp.Result.Origin(name)
+		origin.Source = ""
+		if origin.URL != key {
+			origin.URL, origin.FinalURL = key, resp.Result.FinalURL
+		}
+	}
+	origin.ContentType = ct
+	p.result.Attachments = append(p.result.Attachments, origin)
+	return name, nil
+}
+
+// attachmentExtension determines the file extension for an attachment with
+// this content type. If the content type is not specific enough, the
+// extension is inferred from the URL's path.
+func attachmentExtension(contentType, urlPath string) (string, bool) {
+	if ext, ok := storage.AttachmentExtension(contentType); ok {
+		return ext, true
+	}
+
+	ct, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		ct = ""
+	}
+	switch ct {
+	case "", "application/octet-stream", "binary/octet-stream", "text/plain":
+		// The remote server doesn't report useful MIME types - infer from the file extension
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(urlPath), "."))
+		if ext == "jpg" {
+			ext = "jpeg"
+		}
+		if storage.IsAttachmentExtension(ext) {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
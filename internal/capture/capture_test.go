@@ -0,0 +1,148 @@
+package capture
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/fetch"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestURL(t *testing.T) {
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, `<!DOCTYPE html><html><head><title>Example page</title>
+<link rel="stylesheet" href="style.css"><script>alert(1)</script></head>
+<body><img src="/img/dot.png"><iframe src="/page"></iframe><object data="x.swf"></object></body></html>`)
+	})
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		io.WriteString(w, `body { background: url("img/dot.png"); }`)
+	})
+	mux.HandleFunc("/img/dot.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	st, err := storage.GetDocStore("fs:" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The test server is on the loopback interface, which is normally blocked
+	allow, _ := fetch.ParseNetworks("127.0.0.0/8, ::1")
+	fetcher := fetch.New("doc-hoarder-test", allow)
+	id, res, err := URL(ctx, st, srv.URL+"/page", fetcher.Fetch, Options{Permissions: storage.Permissions{Owner: "alice"}, Tags: []string{"Test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Title != "Example page" {
+		t.Errorf("Unexpected title '%s'", res.Title)
+	}
+
+	trns, err := st.GetDocument(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trns.Rollback()
+
+	meta, err := storage.ReadMeta(ctx, trns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Status != storage.StatusStatic || meta.URL != srv.URL+"/page" || meta.Permissions.Owner != "alice" || len(meta.Tags) != 1 || meta.Tags[0] != "test" {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+
+	f, err := trns.ReadRootFile(ctx, "document.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := io.ReadAll(f)
+	f.Close()
+	for _, s := range []string{"<script", "<iframe", "<object", "style.css", "/img/dot.png"} {
+		if strings.Contains(string(doc), s) {
+			t.Errorf("Captured document should not contain '%s'", s)
+		}
+	}
+
+	atts, err := trns.ListAttachments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	css := ""
+	for _, att := range atts {
+		if strings.HasSuffix(att, ".css") {
+			g, err := trns.ReadAttachment(ctx, att)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(g)
+			g.Close()
+			css = string(b)
+		}
+	}
+	if len(atts) != 2 || css == "" {
+		t.Fatalf("Expected a stylesheet and an image; got %v", atts)
+	}
+	if strings.Contains(css, "img/dot.png") || !strings.Contains(css, ".png") {
+		t.Errorf("References in the stylesheet were not rewritten: %s", css)
+	}
+
+	if _, _, err := URL(ctx, st, srv.URL+"/page", fetcher.Fetch, Options{}); err != ErrNoOwner {
+		t.Errorf("Captures without an owner should fail; got error %v", err)
+	}
+	if _, _, err := URL(ctx, st, srv.URL+"/style.css", fetcher.Fetch, Options{Permissions: storage.Permissions{Owner: "alice"}}); err == nil {
+		t.Errorf("Capturing something that is not a web page should fail")
+	}
+
+	// Stylesheets that are too large to rewrite are left out, rather than truncated
+	largeCSS := func(ctx context.Context, u *url.URL) (string, io.ReadCloser, error) {
+		if u.Path == "/style.css" {
+			body := io.LimitReader(repeatReader('x'), maxStylesheetSize+1)
+			return "text/css", io.NopCloser(body), nil
+		}
+		return fetcher.Fetch(ctx, u)
+	}
+	id, res, err = URL(ctx, st, srv.URL+"/page", largeCSS, Options{Permissions: storage.Permissions{Owner: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Missing) != 1 || res.Missing[0] != srv.URL+"/style.css" {
+		t.Errorf("Expected the stylesheet to be missing; got %v", res.Missing)
+	}
+	trns, err = st.GetDocument(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trns.Rollback()
+	atts, err = trns.ListAttachments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, att := range atts {
+		if strings.HasSuffix(att, ".css") {
+			t.Errorf("A truncated stylesheet %s was stored", att)
+		}
+	}
+}
+
+// repeatReader produces the same byte indefinitely
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
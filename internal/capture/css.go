@@ -0,0 +1,47 @@
+package capture
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+)
+
+var (
+	cssURL    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+	cssImport = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// rewriteCSS attaches all resources referenced in a stylesheet, and replaces
+// the references with the attachment's filename.
+func (p *page) rewriteCSS(ctx context.Context, css string, base *url.URL, prefix string) string {
+	replace := func(format string) func(string) string {
+		return func(m string) string {
+			var sub []string
+			if format == "url" {
+				sub = cssURL.FindStringSubmatch(m)
+			} else {
+				sub = cssImport.FindStringSubmatch(m)
+			}
+			ref := ""
+			for _, s := range sub[1:] {
+				if s != "" {
+					ref = s
+					break
+				}
+			}
+
+			s, ok := p.attachRef(ctx, ref, base, prefix)
+			if !ok {
+				return m
+			}
+			if format == "url" {
+				return `url("` + s + `")`
+			}
+			return `@import "` + s + `"`
+		}
+	}
+
+	css = cssImport.ReplaceAllStringFunc(css, replace("import"))
+	css = cssURL.ReplaceAllStringFunc(css, replace("url"))
+	return css
+}
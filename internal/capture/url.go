@@ -0,0 +1,133 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// ErrDiscarded is returned when a capture was discarded by Options.Keep
+var ErrDiscarded = errors.New("capture discarded")
+
+// ErrNoOwner is returned when a capture would have no owner, and would not be public either
+var ErrNoOwner = errors.New("captured documents need an owner, unless they are public")
+
+// Options control the metadata of captured documents
+type Options struct {
+	Permissions storage.Permissions
+	Tags        []string
+
+	// Keep, if set, is called with the visible text of the page before
+	// anything is stored. If it returns false, the capture is discarded, and
+	// URL returns ErrDiscarded.
+	Keep func(ctx context.Context, text string) (bool, error)
+}
+
+// URL downloads a web page and its subresources, and stores them as a new,
+// finalized document. It returns the new document's ID.
+func URL(ctx context.Context, st storage.DocStore, pageURL string, fetch FetchFunc, opts Options) (string, Result, error) {
+	var res Result
+	if opts.Permissions.Owner == "" && !opts.Permissions.Public {
+		return "", res, ErrNoOwner
+	}
+
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", res, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", res, fmt.Errorf("unsupported URL scheme '%s'", u.Scheme)
+	}
+
+	contentType, body, err := fetch(ctx, u)
+	if err != nil {
+		return "", res, err
+	}
+	defer body.Close()
+	if ct, _, _ := mime.ParseMediaType(contentType); ct != "text/html" && ct != "application/xhtml+xml" {
+		return "", res, fmt.Errorf("%s is not a web page, but '%s'", pageURL, contentType)
+	}
+
+	var page io.Reader = body
+	if opts.Keep != nil {
+		buf, err := io.ReadAll(body)
+		if err != nil {
+			return "", res, err
+		}
+		text, err := Text(bytes.NewReader(buf))
+		if err != nil {
+			return "", res, err
+		}
+		keep, err := opts.Keep(ctx, text)
+		if err != nil {
+			return "", res, err
+		} else if !keep {
+			return "", res, ErrDiscarded
+		}
+		page = bytes.NewReader(buf)
+	}
+
+	id, err := st.NewDocumentID(ctx)
+	if err != nil {
+		return "", res, err
+	}
+	trns, err := st.GetDocument(id)
+	if err != nil {
+		return "", res, err
+	}
+	commit := false
+	defer func() {
+		if !commit {
+			trns.Rollback()
+		}
+	}()
+
+	res, err = Page(ctx, trns, u, page, fetch)
+	if err != nil {
+		return "", res, err
+	}
+	for i := range res.Attachments {
+		res.Attachments[i].Source = storage.SourceProxied
+	}
+	err = storage.RecordAttachments(ctx, trns, res.Attachments...)
+	if err != nil {
+		return "", res, err
+	}
+
+	meta := storage.DocumentMeta{
+		Title:       res.Title,
+		URL:         u.String(),
+		ContentType: "text/html",
+		IconID:      res.IconID,
+		Status:      storage.StatusStatic,
+		CaptureDate: time.Now(),
+		Tags:        storage.NormalizeTags(opts.Tags),
+	}
+	if meta.Title == "" {
+		meta.Title = path.Base(u.Path)
+	}
+	if meta.Title == "" || meta.Title == "/" || meta.Title == "." {
+		meta.Title = u.Host
+	}
+	meta.Permissions = opts.Permissions
+
+	err = storage.WriteMeta(ctx, trns, meta)
+	if err != nil {
+		return "", res, err
+	}
+
+	err = trns.Commit(ctx, "Capture "+u.String())
+	if err != nil {
+		return "", res, err
+	}
+	commit = true
+	return id, res, nil
+}
@@ -0,0 +1,148 @@
+// Package collection implements ordered, titled lists of documents, which have
+// their own permissions and can be shared as a unit.
+package collection
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// ErrNotPresent is returned when a collection does not exist
+var ErrNotPresent = errors.New("collection not present")
+
+// A Collection is an ordered list of documents
+type Collection struct {
+	ID          string
+	Title       string
+	Description string
+	Created     time.Time
+	Modified    time.Time
+
+	// Permissions determine who can see and modify this collection. Anyone
+	// who can see a collection can also see the documents in it.
+	Permissions storage.Permissions
+
+	// Documents contains the IDs of the documents in this collection, in order
+	Documents []string
+}
+
+// NewCollectionID generates a random ID for a collection
+func NewCollectionID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// IsOwner checks if this principal owns the collection
+func (c Collection) IsOwner(p storage.Principal) bool {
+	return c.Permissions.IsOwner(p)
+}
+
+// CanRead checks if a user is allowed to see this collection
+func (c Collection) CanRead(p storage.Principal) bool {
+	return c.Permissions.CanRead(p)
+}
+
+// CanWrite checks if a user is allowed to modify this collection
+func (c Collection) CanWrite(p storage.Principal) bool {
+	return c.Permissions.CanWrite(p)
+}
+
+// Contains checks if a document is part of this collection
+func (c Collection) Contains(docID string) bool {
+	for _, id := range c.Documents {
+		if id == docID {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends a document to the end of this collection, if it's not in it already
+func (c *Collection) Add(docID string) {
+	if !c.Contains(docID) {
+		c.Documents = append(c.Documents, docID)
+	}
+}
+
+// Remove removes a document from this collection
+func (c *Collection) Remove(docID string) {
+	docs := c.Documents[:0]
+	for _, id := range c.Documents {
+		if id != docID {
+			docs = append(docs, id)
+		}
+	}
+	c.Documents = docs
+}
+
+// Move moves a document up (negative offset) or down (positive offset) in
+// this collection.
+func (c *Collection) Move(docID string, offset int) {
+	for i, id := range c.Documents {
+		if id != docID {
+			continue
+		}
+		j := i + offset
+		if j < 0 {
+			j = 0
+		} else if j >= len(c.Documents) {
+			j = len(c.Documents) - 1
+		}
+		copy(c.Documents[i:], c.Documents[i+1:])
+		copy(c.Documents[j+1:], c.Documents[j:len(c.Documents)-1])
+		c.Documents[j] = docID
+		return
+	}
+}
+
+type Store interface {
+	// NewCollection stores a new collection. It assigns the collection's ID and creation date.
+	NewCollection(context.Context, Collection) (Collection, error)
+	GetCollection(context.Context, string) (Collection, error)
+
+	// GetCollections lists all collections a user can see
+	GetCollections(context.Context, storage.Principal) ([]Collection, error)
+
+	// GetCollectionsForDocument lists all collections a document belongs to
+	GetCollectionsForDocument(context.Context, string) ([]Collection, error)
+
+	// UpdateCollection replaces a collection. Its owner and creation date cannot be changed.
+	UpdateCollection(context.Context, Collection) (Collection, error)
+	DeleteCollection(context.Context, string) error
+}
+
+type StorageMethod func(string) (Store, error)
+
+var allStorageMethods map[string]StorageMethod
+
+func RegisterStorageMethod(name string, f StorageMethod) {
+	if allStorageMethods == nil {
+		allStorageMethods = make(map[string]StorageMethod)
+	}
+	allStorageMethods[name] = f
+}
+
+func GetStore(descriptor string) (Store, error) {
+	i := strings.IndexRune(descriptor, ':')
+	name := ""
+	if i >= 0 {
+		name = descriptor[:i]
+		descriptor = descriptor[i+1:]
+	}
+	if allStorageMethods == nil {
+		return nil, fmt.Errorf("no storage backends have been initialized")
+	}
+
+	if f, ok := allStorageMethods[name]; ok {
+		return f(descriptor)
+	}
+	return nil, fmt.Errorf("storage backend '%s' not registered", name)
+}
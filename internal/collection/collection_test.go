@@ -0,0 +1,146 @@
+package collection
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestOrder(t *testing.T) {
+	c := Collection{}
+	for _, id := range []string{"a", "b", "c", "d", "b"} {
+		c.Add(id)
+	}
+	if !reflect.DeepEqual(c.Documents, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Unexpected documents after adding: %v", c.Documents)
+	}
+
+	c.Move("c", -2)
+	if !reflect.DeepEqual(c.Documents, []string{"c", "a", "b", "d"}) {
+		t.Errorf("Unexpected documents after moving up: %v", c.Documents)
+	}
+	c.Move("a", 10)
+	if !reflect.DeepEqual(c.Documents, []string{"c", "b", "d", "a"}) {
+		t.Errorf("Unexpected documents after moving down: %v", c.Documents)
+	}
+	c.Remove("b")
+	if !reflect.DeepEqual(c.Documents, []string{"c", "d", "a"}) {
+		t.Errorf("Unexpected documents after removing: %v", c.Documents)
+	}
+}
+
+func TestCollections(t *testing.T) {
+	ctx := context.Background()
+	fileName := path.Join(t.TempDir(), "collections.json")
+	alice := storage.Principal{UserID: "alice"}
+	bob := storage.Principal{UserID: "bob", Groups: []string{"research"}}
+
+	st, err := GetStore("file:" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Collection{Title: "Sources", Documents: []string{"0123456789"}}
+	c.Permissions.Owner = alice.UserID
+	c, err = st.NewCollection(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cc, _ := st.GetCollections(ctx, bob); len(cc) != 0 {
+		t.Errorf("Bob should not see any collections yet; got %v", cc)
+	}
+
+	c.Permissions = storage.Permissions{Owner: "mallory", ReadGroups: []string{"research"}}
+	c, err = st.UpdateCollection(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Permissions.Owner != alice.UserID {
+		t.Errorf("The owner of a collection should not change; got %s", c.Permissions.Owner)
+	}
+
+	// Reopen the store; the collection should survive
+	st, err = GetStore("file:" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc, _ := st.GetCollections(ctx, bob); len(cc) != 1 || cc[0].ID != c.ID || cc[0].CanWrite(bob) {
+		t.Errorf("Bob should be able to read (only) the collection; got %v", cc)
+	}
+	if cc, _ := st.GetCollectionsForDocument(ctx, "0123456789"); len(cc) != 1 {
+		t.Errorf("Expected one collection for the document; got %v", cc)
+	}
+
+	err = st.DeleteCollection(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.GetCollection(ctx, c.ID); err != ErrNotPresent {
+		t.Errorf("Expected ErrNotPresent for a deleted collection; got %v", err)
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	ctx := context.Background()
+	docs, err := storage.GetDocStore("fs:" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Collection{Title: "Sources"}
+	for _, title := range []string{"First", "Second"} {
+		id, err := docs.NewDocumentID(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trns, err := docs.GetDocument(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = storage.WriteMeta(ctx, trns, storage.DocumentMeta{Title: title, Status: storage.StatusStatic})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := trns.WriteRootFile(ctx, "document.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("<p>" + title + "</p>"))
+		w.Close()
+		err = trns.Commit(ctx, "create document")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Add(id)
+	}
+
+	var buf bytes.Buffer
+	err = WriteZip(ctx, &buf, docs, c, c.Documents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(z.File))
+	for i, f := range z.File {
+		names[i] = f.Name
+	}
+	expected := []string{
+		"001-g" + c.Documents[0] + "/document.html",
+		"001-g" + c.Documents[0] + "/meta.xml",
+		"002-g" + c.Documents[1] + "/document.html",
+		"002-g" + c.Documents[1] + "/meta.xml",
+		"index.html",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected files %v; got %v", expected, names)
+	}
+}
@@ -0,0 +1,126 @@
+package collection
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<title>{{.Title}}</title>
+	</head>
+	<body>
+		<h1>{{.Title}}</h1>
+		{{if .Description}}<p>{{.Description}}</p>{{end}}
+		<ol>
+			{{range .Documents}}<li><a href="{{.Dir}}/document.html">{{.Meta.Title}}</a>{{if .Meta.URL}} ({{.Meta.URL}}){{end}}</li>
+			{{end}}
+		</ol>
+	</body>
+</html>
+`))
+
+// WriteZip writes a collection to a zip file. Each document gets its own
+// directory, numbered in collection order, and an index page links to all of
+// them. Only the documents listed in docIDs are included.
+func WriteZip(ctx context.Context, w io.Writer, st storage.DocStore, c Collection, docIDs []string) error {
+	type indexEntry struct {
+		Dir  string
+		Meta storage.DocumentMeta
+	}
+	index := struct {
+		Title       string
+		Description string
+		Documents   []indexEntry
+	}{c.Title, c.Description, nil}
+
+	z := zip.NewWriter(w)
+	for i, docID := range docIDs {
+		dir := fmt.Sprintf("%03d-g%s", i+1, docID)
+		meta, err := writeDocument(ctx, z, st, docID, dir)
+		if err != nil {
+			return fmt.Errorf("error exporting document %s: %w", docID, err)
+		}
+		index.Documents = append(index.Documents, indexEntry{dir, meta})
+	}
+
+	f, err := z.CreateHeader(&zip.FileHeader{Name: "index.html", Method: zip.Deflate, Modified: c.Modified})
+	if err != nil {
+		return err
+	}
+	err = indexTemplate.Execute(f, index)
+	if err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// writeDocument adds a document and its attachments to a zip file
+func writeDocument(ctx context.Context, z *zip.Writer, st storage.DocStore, docID, dir string) (storage.DocumentMeta, error) {
+	trns, err := st.GetDocument(docID)
+	if err != nil {
+		return storage.DocumentMeta{}, err
+	}
+	defer trns.Rollback()
+
+	meta, err := storage.ReadMeta(ctx, trns)
+	if err != nil {
+		return meta, err
+	}
+	date := meta.CaptureDate
+	if date.IsZero() {
+		date = meta.Date
+	}
+
+	copyFile := func(name string, r io.ReadCloser, err error) error {
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		f, err := z.CreateHeader(&zip.FileHeader{Name: dir + "/" + name, Method: zip.Deflate, Modified: date})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		return err
+	}
+
+	r, err := trns.ReadRootFile(ctx, "document.bin")
+	if !errors.Is(err, fs.ErrNotExist) {
+		if err = copyFile("document.html", r, err); err != nil {
+			return meta, err
+		}
+	}
+	r, err = trns.ReadRootFile(ctx, "meta.xml")
+	if err = copyFile("meta.xml", r, err); err != nil {
+		return meta, err
+	}
+	r, err = trns.ReadRootFile(ctx, "manifest.xml")
+	if !errors.Is(err, fs.ErrNotExist) {
+		if err = copyFile("manifest.xml", r, err); err != nil {
+			return meta, err
+		}
+	}
+
+	atts, err := trns.ListAttachments(ctx)
+	if err != nil {
+		return meta, err
+	}
+	for _, att := range atts {
+		r, err := trns.ReadAttachment(ctx, att)
+		if err = copyFile("att/"+att, r, err); err != nil {
+			return meta, err
+		}
+	}
+
+	return meta, nil
+}
@@ -0,0 +1,176 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+type mapStore struct {
+	Mu          *sync.Mutex
+	Collections map[string]Collection
+	Filename    string
+}
+
+func (m mapStore) NewCollection(ctx context.Context, c Collection) (Collection, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	for i := 0; i < 32; i++ {
+		c.ID = NewCollectionID()
+		if _, ok := m.Collections[c.ID]; !ok {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return c, err
+		}
+	}
+	c.Created = time.Now()
+	c.Modified = c.Created
+	if c.Documents == nil {
+		c.Documents = []string{}
+	}
+
+	m.Collections[c.ID] = c
+	return c, m.saveContents(ctx)
+}
+
+func (m mapStore) GetCollection(ctx context.Context, id string) (Collection, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv, ok := m.Collections[id]
+	if !ok {
+		return rv, ErrNotPresent
+	}
+	return rv, nil
+}
+
+func (m mapStore) GetCollections(ctx context.Context, p storage.Principal) ([]Collection, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv := []Collection{}
+	for _, c := range m.Collections {
+		if c.CanRead(p) {
+			rv = append(rv, c)
+		}
+	}
+	sortCollections(rv)
+	return rv, nil
+}
+
+func (m mapStore) GetCollectionsForDocument(ctx context.Context, docID string) ([]Collection, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv := []Collection{}
+	for _, c := range m.Collections {
+		if c.Contains(docID) {
+			rv = append(rv, c)
+		}
+	}
+	sortCollections(rv)
+	return rv, nil
+}
+
+func (m mapStore) UpdateCollection(ctx context.Context, c Collection) (Collection, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	old, ok := m.Collections[c.ID]
+	if !ok {
+		return c, ErrNotPresent
+	}
+	c.Permissions.Owner = old.Permissions.Owner
+	c.Created = old.Created
+	c.Modified = time.Now()
+	if c.Documents == nil {
+		c.Documents = []string{}
+	}
+
+	m.Collections[c.ID] = c
+	return c, m.saveContents(ctx)
+}
+
+func (m mapStore) DeleteCollection(ctx context.Context, id string) error {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	if _, ok := m.Collections[id]; !ok {
+		return ErrNotPresent
+	}
+	delete(m.Collections, id)
+	return m.saveContents(ctx)
+}
+
+// sortCollections sorts collections by title
+func sortCollections(cc []Collection) {
+	sort.Slice(cc, func(i, j int) bool {
+		if cc[i].Title != cc[j].Title {
+			return cc[i].Title < cc[j].Title
+		}
+		return cc[i].ID < cc[j].ID
+	})
+}
+
+func (m mapStore) saveContents(ctx context.Context) error {
+	if m.Filename == "" {
+		return nil
+	}
+
+	// Save map to filesystem
+	f, err := os.Create(m.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	en := json.NewEncoder(f)
+	en.SetIndent("", "\t")
+	return en.Encode(m.Collections)
+}
+
+func init() {
+	newMapStore := func(_descriptor string) (Store, error) {
+		return mapStore{
+			Mu:          &sync.Mutex{},
+			Collections: make(map[string]Collection),
+		}, nil
+	}
+	RegisterStorageMethod("", newMapStore)
+	RegisterStorageMethod("map", newMapStore)
+	RegisterStorageMethod("memory", newMapStore)
+
+	newFSStore := func(fileName string) (Store, error) {
+		rv := mapStore{
+			Mu:          &sync.Mutex{},
+			Collections: make(map[string]Collection),
+			Filename:    fileName,
+		}
+
+		f, err := os.Open(fileName)
+		if errors.Is(err, fs.ErrNotExist) {
+			return rv, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		err = dec.Decode(&rv.Collections)
+		if rv.Collections == nil {
+			rv.Collections = make(map[string]Collection)
+		}
+		return rv, err
+	}
+	RegisterStorageMethod("fs", newFSStore)
+	RegisterStorageMethod("file", newFSStore)
+	RegisterStorageMethod("json", newFSStore)
+}
@@ -0,0 +1,142 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestLines(t *testing.T) {
+	cases := []struct {
+		A, B     string
+		Expected string
+	}{
+		{"", "", ""},
+		{"a b c", "a b c", " a b c"},
+		{"", "a b", "+a+b"},
+		{"a b", "", "-a-b"},
+		{"a b c", "a c", " a-b c"},
+		{"a c", "a b c", " a+b c"},
+		{"a b c d", "a x c y", " a-b+x c-d+y"},
+		{"a b c a b b a", "c b a b a c", "-a-b c+b a b-b a+c"},
+	}
+
+	for _, c := range cases {
+		var a, b []string
+		if c.A != "" {
+			a = strings.Split(c.A, " ")
+		}
+		if c.B != "" {
+			b = strings.Split(c.B, " ")
+		}
+
+		var s strings.Builder
+		var edits int
+		var gotA, gotB []string
+		for _, l := range Lines(a, b) {
+			switch l.Op {
+			case Equal:
+				s.WriteString(" ")
+				gotA = append(gotA, l.Text)
+				gotB = append(gotB, l.Text)
+			case Insert:
+				s.WriteString("+")
+				gotB = append(gotB, l.Text)
+				edits++
+			case Delete:
+				s.WriteString("-")
+				gotA = append(gotA, l.Text)
+				edits++
+			}
+			s.WriteString(l.Text)
+		}
+
+		if strings.Join(gotA, " ") != c.A || strings.Join(gotB, " ") != c.B {
+			t.Errorf("Diff '%s' -> '%s' doesn't reproduce its inputs: '%s'", c.A, c.B, s.String())
+		}
+
+		if s.String() != c.Expected {
+			// Several shortest edit scripts may exist; only the number of edits has to be minimal
+			expectedEdits := strings.Count(c.Expected, "+") + strings.Count(c.Expected, "-")
+			if edits != expectedEdits {
+				t.Errorf("Diff '%s' -> '%s': expected '%s'; got '%s'", c.A, c.B, c.Expected, s.String())
+			}
+		}
+	}
+}
+
+func TestLinesUnrelated(t *testing.T) {
+	// Two unrelated documents need more than MaxEdits edits, so they get a
+	// coarse diff rather than an unbounded amount of memory
+	var a, b []string
+	for i := 0; i < 20000; i++ {
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+	a = append(a, "footer")
+	b = append(b, "footer")
+
+	lines := Lines(a, b)
+	if len(lines) != 40001 {
+		t.Fatalf("Expected 40001 lines; got %d", len(lines))
+	}
+	for i, l := range lines {
+		switch {
+		case i < 20000:
+			if l.Op != Delete || l.Text != a[i] {
+				t.Fatalf("Line %d: expected deletion of %s; got %v %s", i, a[i], l.Op, l.Text)
+			}
+		case i < 40000:
+			if l.Op != Insert || l.Text != b[i-20000] {
+				t.Fatalf("Line %d: expected insertion of %s; got %v %s", i, b[i-20000], l.Op, l.Text)
+			}
+		default:
+			if l.Op != Equal || l.Text != "footer" {
+				t.Fatalf("Line %d: expected the common suffix; got %v %s", i, l.Op, l.Text)
+			}
+		}
+	}
+}
+
+func TestMeta(t *testing.T) {
+	var a, b storage.DocumentMeta
+	a.Title = "Foo"
+	b.Title = "Bar"
+	a.URL = "https://example.org/"
+	b.URL = a.URL
+	b.CaptureDate = time.Date(2023, 1, 2, 17, 7, 12, 0, time.UTC)
+
+	changes := Meta(a, b)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes; got %v", changes)
+	}
+	if changes[0] != (FieldChange{"Title", "Foo", "Bar"}) {
+		t.Errorf("Unexpected change %v", changes[0])
+	}
+	if changes[1] != (FieldChange{"CaptureDate", "", "2023-01-02T17:07:12Z"}) {
+		t.Errorf("Unexpected change %v", changes[1])
+	}
+}
+
+func TestHunks(t *testing.T) {
+	a := strings.Split("a b c d e f g h i j", " ")
+	b := strings.Split("a B c d e f g h i J", " ")
+
+	hunks := Hunks(Lines(a, b), 1)
+	if len(hunks) != 2 {
+		t.Fatalf("Expected 2 hunks; got %v", hunks)
+	}
+	if len(hunks[0]) != 4 || hunks[0][0].Text != "a" || hunks[0][3].Text != "c" {
+		t.Errorf("Unexpected first hunk %v", hunks[0])
+	}
+	if len(hunks[1]) != 3 || hunks[1][0].Text != "i" {
+		t.Errorf("Unexpected second hunk %v", hunks[1])
+	}
+
+	if hunks := Hunks(Lines(a, b), 4); len(hunks) != 1 {
+		t.Errorf("Overlapping hunks should be merged; got %v", hunks)
+	}
+}
@@ -0,0 +1,152 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/doctext"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// A FieldChange records a metadata field that differs between two versions
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// A Result contains all differences between two versions of a document
+type Result struct {
+	Text               []Line
+	AddedAttachments   []string
+	RemovedAttachments []string
+	Meta               []FieldChange
+}
+
+// Changed returns true if there are any differences at all
+func (res Result) Changed() bool {
+	if len(res.AddedAttachments) > 0 || len(res.RemovedAttachments) > 0 || len(res.Meta) > 0 {
+		return true
+	}
+	for _, l := range res.Text {
+		if l.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// Documents compares two versions of a document. These can be different
+// revisions of the same document, or different documents altogether.
+func Documents(ctx context.Context, a, b storage.DocTransaction) (Result, error) {
+	var rv Result
+
+	textA, err := documentText(ctx, a)
+	if err != nil {
+		return rv, err
+	}
+	textB, err := documentText(ctx, b)
+	if err != nil {
+		return rv, err
+	}
+	rv.Text = Lines(textA, textB)
+
+	attsA, err := a.ListAttachments(ctx)
+	if err != nil {
+		return rv, err
+	}
+	attsB, err := b.ListAttachments(ctx)
+	if err != nil {
+		return rv, err
+	}
+	rv.RemovedAttachments = subtract(attsA, attsB)
+	rv.AddedAttachments = subtract(attsB, attsA)
+
+	metaA, err := storage.ReadMeta(ctx, a)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return rv, err
+	}
+	metaB, err := storage.ReadMeta(ctx, b)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return rv, err
+	}
+	rv.Meta = Meta(metaA, metaB)
+
+	return rv, nil
+}
+
+// documentText returns the lines of visible text in a document
+func documentText(ctx context.Context, trns storage.DocTransaction) ([]string, error) {
+	f, err := trns.ReadRootFile(ctx, "document.bin")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	text, err := doctext.Extract(f)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+// subtract returns all elements of a that are not in b
+func subtract(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+	}
+	var rv []string
+	for _, s := range a {
+		if !inB[s] {
+			rv = append(rv, s)
+		}
+	}
+	return rv
+}
+
+// Meta lists all fields that differ between two versions of a document's metadata
+func Meta(a, b storage.DocumentMeta) []FieldChange {
+	var rv []FieldChange
+	field := func(name, old, new string) {
+		if old != new {
+			rv = append(rv, FieldChange{name, old, new})
+		}
+	}
+	date := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	list := func(l []string) string {
+		return strings.Join(l, ", ")
+	}
+
+	field("Title", a.Title, b.Title)
+	field("Author", a.Author, b.Author)
+	field("URL", a.URL, b.URL)
+	field("ContentType", a.ContentType, b.ContentType)
+	field("IconID", a.IconID, b.IconID)
+	field("Date", date(a.Date), date(b.Date))
+	field("Status", string(a.Status), string(b.Status))
+	field("CaptureDate", date(a.CaptureDate), date(b.CaptureDate))
+	field("Owner", a.Permissions.Owner, b.Permissions.Owner)
+	field("Public", fmt.Sprint(a.Permissions.Public), fmt.Sprint(b.Permissions.Public))
+	field("ReadUsers", list(a.Permissions.ReadUsers), list(b.Permissions.ReadUsers))
+	field("ReadGroups", list(a.Permissions.ReadGroups), list(b.Permissions.ReadGroups))
+	field("WriteUsers", list(a.Permissions.WriteUsers), list(b.Permissions.WriteUsers))
+	field("WriteGroups", list(a.Permissions.WriteGroups), list(b.Permissions.WriteGroups))
+
+	return rv
+}
@@ -0,0 +1,192 @@
+// Package diff compares two versions of a captured document
+package diff
+
+// An Op describes what happened to a line between two versions
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+func (op Op) String() string {
+	switch op {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+// A Line is a single line in a line-based diff
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// MaxEdits limits the number of edits Lines searches for. Memory use grows
+// with the square of the number of edits, so documents that differ more than
+// this are diffed coarsely instead.
+const MaxEdits = 2000
+
+// Lines computes a shortest edit script that turns a into b, using the
+// algorithm described in Myers (1986), "An O(ND) Difference Algorithm and Its
+// Variations". If the shortest edit script is longer than MaxEdits, all
+// lines between the common prefix and suffix are shown as deleted and then
+// inserted.
+func Lines(a, b []string) []Line {
+	// Strip the common prefix and suffix; these are typically most of a page
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	rv := make([]Line, 0, len(a)+len(b)-prefix-suffix)
+	for _, s := range a[:prefix] {
+		rv = append(rv, Line{Equal, s})
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if lines, ok := myers(middleA, middleB, MaxEdits); ok {
+		rv = append(rv, lines...)
+	} else {
+		for _, s := range middleA {
+			rv = append(rv, Line{Delete, s})
+		}
+		for _, s := range middleB {
+			rv = append(rv, Line{Insert, s})
+		}
+	}
+	for _, s := range a[len(a)-suffix:] {
+		rv = append(rv, Line{Equal, s})
+	}
+	return rv
+}
+
+// myers finds a shortest edit script of at most maxEdits edits, or returns
+// false if there is none
+func myers(a, b []string, maxEdits int) ([]Line, bool) {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil, true
+	}
+
+	max := n + m
+	if max > maxEdits {
+		max = maxEdits
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] holds the furthest reaching x for diagonals -d..d before
+	// round d, so that its size grows with d rather than with n+m
+	var trace [][]int
+
+	// Find the furthest reaching path for every number of edits d, until one reaches (n,m)
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		vc := make([]int, 2*d+1)
+		copy(vc, v[offset-d:offset+d+1])
+		trace = append(trace, vc)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// Walk back through the trace to reconstruct the edit script
+	rv := make([]Line, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		vd := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && vd[d+k-1] < vd[d+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = vd[d+prevK]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rv = append(rv, Line{Equal, a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				rv = append(rv, Line{Insert, b[y]})
+			} else {
+				x--
+				rv = append(rv, Line{Delete, a[x]})
+			}
+		}
+	}
+
+	for i, j := 0, len(rv)-1; i < j; i, j = i+1, j-1 {
+		rv[i], rv[j] = rv[j], rv[i]
+	}
+	return rv, true
+}
+
+// Hunks splits a diff into groups of changed lines, each surrounded by at most
+// n unchanged lines of context. Unchanged lines further away from any change
+// are omitted.
+func Hunks(lines []Line, n int) [][]Line {
+	var rv [][]Line
+	start, end := -1, -1
+	for i, l := range lines {
+		if l.Op == Equal {
+			continue
+		}
+		lo, hi := i-n, i+n+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(lines) {
+			hi = len(lines)
+		}
+		if start >= 0 && lo <= end {
+			end = hi
+			continue
+		}
+		if start >= 0 {
+			rv = append(rv, lines[start:end])
+		}
+		start, end = lo, hi
+	}
+	if start >= 0 {
+		rv = append(rv, lines[start:end])
+	}
+	return rv
+}
@@ -0,0 +1,100 @@
+// Package doctext extracts the human-readable text from a captured document
+package doctext
+
+import (
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Extract reads an HTML document, and returns its visible text. Block-level
+// elements are separated by newlines, and runs of whitespace are collapsed.
+func Extract(r io.Reader) (string, error) {
+	var b strings.Builder
+	z := html.NewTokenizer(r)
+
+	skipDepth := 0
+	pendingSpace := false
+	pendingBreak := false
+
+	write := func(s string) {
+		for _, c := range s {
+			if unicode.IsSpace(c) {
+				pendingSpace = true
+				continue
+			}
+			if b.Len() > 0 {
+				if pendingBreak {
+					b.WriteRune('\n')
+				} else if pendingSpace {
+					b.WriteRune(' ')
+				}
+			}
+			pendingSpace, pendingBreak = false, false
+			b.WriteRune(c)
+		}
+	}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return b.String(), nil
+			}
+			return b.String(), z.Err()
+
+		case html.TextToken:
+			if skipDepth == 0 {
+				write(string(z.Text()))
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			if skipped[a] && tt == html.StartTagToken {
+				skipDepth++
+			}
+			if blocks[a] {
+				pendingBreak = true
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			if skipped[a] && skipDepth > 0 {
+				skipDepth--
+			}
+			if blocks[a] {
+				pendingBreak = true
+			}
+		}
+	}
+}
+
+// skipped lists the elements whose contents are not part of the visible text
+var skipped = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Object:   true,
+	atom.Iframe:   true,
+}
+
+// blocks lists the elements that start a new line of text
+var blocks = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Br: true, atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Fieldset: true, atom.Figcaption: true, atom.Figure: true, atom.Footer: true,
+	atom.Form: true, atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true,
+	atom.H5: true, atom.H6: true, atom.Header: true, atom.Hr: true, atom.Li: true,
+	atom.Main: true, atom.Nav: true, atom.Ol: true, atom.P: true, atom.Pre: true,
+	atom.Section: true, atom.Table: true, atom.Td: true, atom.Th: true, atom.Tr: true,
+	atom.Ul: true,
+}
@@ -0,0 +1,92 @@
+// Package drafts keeps track of the open transactions of documents that are
+// still being uploaded.
+package drafts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// ErrNotPresent is returned when a draft does not exist, or has expired
+var ErrNotPresent = errors.New("draft not present")
+
+// ErrForbidden is returned when a draft is used by someone other than the user who started it
+var ErrForbidden = errors.New("this draft belongs to a different user")
+
+// ErrLost is returned for drafts that were started before a restart, if the
+// document store cannot resume their transactions
+var ErrLost = errors.New("this draft was lost when the server restarted")
+
+// A Draft is a document that is being uploaded. All uploads go into a single
+// transaction, which is committed once the draft is finalized.
+type Draft struct {
+	ID         string
+	DocumentID string
+
+	// User and Scope are the user ID and the scope of the API key that
+	// started this draft. Only requests with the same user and scope can use
+	// it.
+	User  string
+	Scope string
+
+	Created  time.Time
+	LastUsed time.Time
+
+	// ResumeToken can be used to reopen the transaction after a restart, if
+	// the document store supports it
+	ResumeToken string `json:",omitempty"`
+
+	// Attachments contains the origins of attachments that will be written
+	// to the manifest once the draft is finalized
+	Attachments []storage.AttachmentOrigin `json:",omitempty"`
+}
+
+// NewDraftID generates a random ID for a draft
+func NewDraftID() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+type Store interface {
+	// SaveDraft creates or updates a draft
+	SaveDraft(context.Context, Draft) error
+	GetDraft(context.Context, string) (Draft, error)
+	GetDrafts(context.Context) ([]Draft, error)
+	DeleteDraft(context.Context, string) error
+}
+
+type StorageMethod func(string) (Store, error)
+
+var allStorageMethods map[string]StorageMethod
+
+func RegisterStorageMethod(name string, f StorageMethod) {
+	if allStorageMethods == nil {
+		allStorageMethods = make(map[string]StorageMethod)
+	}
+	allStorageMethods[name] = f
+}
+
+func GetStore(descriptor string) (Store, error) {
+	i := strings.IndexRune(descriptor, ':')
+	name := ""
+	if i >= 0 {
+		name = descriptor[:i]
+		descriptor = descriptor[i+1:]
+	}
+	if allStorageMethods == nil {
+		return nil, fmt.Errorf("no storage backends have been initialized")
+	}
+
+	if f, ok := allStorageMethods[name]; ok {
+		return f(descriptor)
+	}
+	return nil, fmt.Errorf("storage backend '%s' not registered", name)
+}
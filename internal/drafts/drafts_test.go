@@ -0,0 +1,130 @@
+package drafts
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestDrafts(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fileName := path.Join(dir, "drafts.json")
+
+	docs, err := storage.GetDocStore("fs:" + path.Join(dir, "doc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := GetStore("file:" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(docs, st, time.Hour)
+
+	begin := func() (string, string) {
+		docID, err := docs.NewDocumentID(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trns, err := docs.GetDocument(docID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := m.Begin(ctx, trns, "alice", "document.create")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id, docID
+	}
+	setTitle := func(title string) func(storage.DocTransaction) error {
+		return func(trns storage.DocTransaction) error {
+			return storage.WriteMeta(ctx, trns, storage.DocumentMeta{Title: title, Status: storage.StatusDraft})
+		}
+	}
+
+	id, docID := begin()
+
+	if err := m.Use(ctx, id, "bob", "document.create", setTitle("Bob's")); err != ErrForbidden {
+		t.Errorf("Other users should not be able to use a draft; got error %v", err)
+	}
+	if err := m.Use(ctx, id, "alice", "other.scope", setTitle("Other scope")); err != ErrForbidden {
+		t.Errorf("API keys with a different scope should not be able to use a draft; got error %v", err)
+	}
+	if err := m.Use(ctx, "nonexistent", "alice", "document.create", setTitle("Nothing")); err != ErrNotPresent {
+		t.Errorf("Expected ErrNotPresent; got %v", err)
+	}
+
+	err = m.Use(ctx, id, "alice", "document.create", setTitle("Alice's"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start over with a new manager, as if the server had restarted
+	st, err = GetStore("file:" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = NewManager(docs, st, time.Hour)
+
+	err = m.Finish(ctx, id, "alice", "document.create", func(trns storage.DocTransaction) error {
+		meta, err := storage.ReadMeta(ctx, trns)
+		if err != nil {
+			return err
+		}
+		if meta.Title != "Alice's" {
+			t.Errorf("Resumed draft has title '%s'", meta.Title)
+		}
+		meta.Status = storage.StatusStatic
+		err = storage.WriteMeta(ctx, trns, meta)
+		if err != nil {
+			return err
+		}
+		return trns.Commit(ctx, "finalize")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Use(ctx, id, "alice", "document.create", setTitle("Again")); err != ErrNotPresent {
+		t.Errorf("Finished drafts should be removed; got error %v", err)
+	}
+
+	trns, err := docs.GetDocument(docID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := storage.ReadMeta(ctx, trns)
+	trns.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	} else if meta.Status != storage.StatusStatic {
+		t.Errorf("Finished draft has status '%s'", meta.Status)
+	}
+
+	// Abandoned drafts get rolled back
+	abandoned, abandonedDoc := begin()
+	err = m.Use(ctx, abandoned, "alice", "document.create", setTitle("Abandoned"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := m.Expire(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 1 || expired[0] != abandoned {
+		t.Errorf("Expected draft %s to expire; got %v", abandoned, expired)
+	}
+	if err := m.Use(ctx, abandoned, "alice", "document.create", setTitle("Too late")); err != ErrNotPresent {
+		t.Errorf("Expired drafts should be removed; got error %v", err)
+	}
+	ids, err := docs.DocumentIDs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if id == abandonedDoc {
+			t.Errorf("Expired draft %s left its document behind", abandonedDoc)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package drafts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// A Manager holds the open transactions of all drafts. Drafts that have not
+// been used for a while are rolled back.
+type Manager struct {
+	docs   storage.DocStore
+	store  Store
+	maxAge time.Duration
+
+	mu   sync.Mutex
+	open map[string]*openDraft
+}
+
+// An openDraft is a draft whose transaction is open in this process
+type openDraft struct {
+	// mu makes sure only one request at a time uses the transaction
+	mu     sync.Mutex
+	trns   storage.DocTransaction
+	closed bool
+
+	// attachments contains the origins of attachments that are written to
+	// the manifest when the draft is finalized
+	attachments []storage.AttachmentOrigin
+}
+
+// NewManager creates a draft manager. Drafts expire if they have not been
+// used for maxAge.
+func NewManager(docs storage.DocStore, store Store, maxAge time.Duration) *Manager {
+	return &Manager{
+		docs:   docs,
+		store:  store,
+		maxAge: maxAge,
+		open:   make(map[string]*openDraft),
+	}
+}
+
+// Begin registers an open transaction as a new draft for a user, and returns
+// the draft's ID
+func (m *Manager) Begin(ctx context.Context, trns storage.DocTransaction, user, scope string) (string, error) {
+	now := time.Now()
+	d := Draft{
+		ID:         NewDraftID(),
+		DocumentID: trns.DocumentID(),
+		User:       user,
+		Scope:      scope,
+		Created:    now,
+		LastUsed:   now,
+	}
+	if rt, ok := trns.(storage.ResumableTransaction); ok {
+		d.ResumeToken, _ = rt.ResumeToken()
+	}
+
+	m.mu.Lock()
+	m.open[d.ID] = &openDraft{trns: trns}
+	m.mu.Unlock()
+
+	err := m.store.SaveDraft(ctx, d)
+	if err != nil {
+		m.mu.Lock()
+		delete(m.open, d.ID)
+		m.mu.Unlock()
+		return "", err
+	}
+	return d.ID, nil
+}
+
+// Use calls f with the transaction of a draft. The origins of attachments
+// recorded in the transaction are kept with the draft, and only written to
+// the manifest when it is finished.
+func (m *Manager) Use(ctx context.Context, id, user, scope string, f func(storage.DocTransaction) error) error {
+	return m.use(ctx, id, user, scope, false, f)
+}
+
+// Finish calls f with the transaction of a draft, and removes the draft if f
+// succeeds. The function f is expected to commit the transaction.
+func (m *Manager) Finish(ctx context.Context, id, user, scope string, f func(storage.DocTransaction) error) error {
+	return m.use(ctx, id, user, scope, true, f)
+}
+
+func (m *Manager) use(ctx context.Context, id, user, scope string, finish bool, f func(storage.DocTransaction) error) error {
+	d, err := m.store.GetDraft(ctx, id)
+	if err != nil {
+		return err
+	}
+	if d.User != user || d.Scope != scope {
+		return ErrForbidden
+	}
+	if m.expired(d, time.Now()) {
+		return ErrNotPresent
+	}
+
+	od, err := m.openDraft(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	if od.closed {
+		return ErrNotPresent
+	}
+
+	buf := &storage.ManifestBuffer{DocTransaction: od.trns, Pending: od.attachments}
+	err = f(buf)
+	od.attachments = buf.Pending
+	if finish && err == nil {
+		od.closed = true
+		m.mu.Lock()
+		delete(m.open, id)
+		m.mu.Unlock()
+		return m.store.DeleteDraft(ctx, id)
+	}
+
+	d.LastUsed = time.Now()
+	d.Attachments = od.attachments
+	if rt, ok := od.trns.(storage.ResumableTransaction); ok {
+		if token, terr := rt.ResumeToken(); terr == nil {
+			d.ResumeToken = token
+		}
+	}
+	if serr := m.store.SaveDraft(ctx, d); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// openDraft returns the open transaction for a draft. If the draft was
+// started before a restart, its transaction is resumed.
+func (m *Manager) openDraft(ctx context.Context, d Draft) (*openDraft, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if od, ok := m.open[d.ID]; ok {
+		return od, nil
+	}
+
+	rs, ok := m.docs.(storage.ResumableStore)
+	if !ok || d.ResumeToken == "" {
+		m.store.DeleteDraft(ctx, d.ID)
+		return nil, ErrLost
+	}
+	trns, err := rs.ResumeDocument(d.DocumentID, d.ResumeToken)
+	if err != nil {
+		m.store.DeleteDraft(ctx, d.ID)
+		return nil, ErrLost
+	}
+
+	od := &openDraft{trns: trns, attachments: d.Attachments}
+	m.open[d.ID] = od
+	return od, nil
+}
+
+func (m *Manager) expired(d Draft, now time.Time) bool {
+	return now.Sub(d.LastUsed) >= m.maxAge
+}
+
+// Expire rolls back all drafts that have not been used for too long, and
+// returns their IDs
+func (m *Manager) Expire(ctx context.Context, now time.Time) ([]string, error) {
+	all, err := m.store.GetDrafts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []string
+	for _, d := range all {
+		if !m.expired(d, now) {
+			continue
+		}
+
+		m.mu.Lock()
+		od, ok := m.open[d.ID]
+		if !ok {
+			// Keep requests from resuming the draft while it is rolled back
+			od = &openDraft{closed: true}
+			m.open[d.ID] = od
+		}
+		m.mu.Unlock()
+
+		if ok {
+			od.mu.Lock()
+			// The draft may have been used since the list of drafts was read
+			cur, err := m.store.GetDraft(ctx, d.ID)
+			if err != nil || od.closed || !m.expired(cur, now) {
+				od.mu.Unlock()
+				continue
+			}
+			od.closed = true
+			od.trns.Rollback()
+			od.mu.Unlock()
+		} else if rs, ok := m.docs.(storage.ResumableStore); ok && d.ResumeToken != "" {
+			// The draft was started before a restart. Resume its transaction
+			// only to discard whatever it staged.
+			if trns, err := rs.ResumeDocument(d.DocumentID, d.ResumeToken); err == nil {
+				trns.Rollback()
+			}
+		}
+
+		m.mu.Lock()
+		if m.open[d.ID] == od {
+			delete(m.open, d.ID)
+		}
+		m.mu.Unlock()
+
+		err = m.store.DeleteDraft(ctx, d.ID)
+		if err != nil {
+			return rv, err
+		}
+		rv = append(rv, d.ID)
+	}
+	return rv, nil
+}
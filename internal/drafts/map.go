@@ -0,0 +1,115 @@
+package drafts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+)
+
+type mapStore struct {
+	Mu       *sync.Mutex
+	Drafts   map[string]Draft
+	Filename string
+}
+
+func (m mapStore) SaveDraft(ctx context.Context, d Draft) error {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	m.Drafts[d.ID] = d
+	return m.saveContents(ctx)
+}
+
+func (m mapStore) GetDraft(ctx context.Context, id string) (Draft, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv, ok := m.Drafts[id]
+	if !ok {
+		return rv, ErrNotPresent
+	}
+	return rv, nil
+}
+
+func (m mapStore) GetDrafts(ctx context.Context) ([]Draft, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv := make([]Draft, 0, len(m.Drafts))
+	for _, d := range m.Drafts {
+		rv = append(rv, d)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Created.Before(rv[j].Created)
+	})
+	return rv, nil
+}
+
+func (m mapStore) DeleteDraft(ctx context.Context, id string) error {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	if _, ok := m.Drafts[id]; !ok {
+		return nil
+	}
+	delete(m.Drafts, id)
+	return m.saveContents(ctx)
+}
+
+func (m mapStore) saveContents(ctx context.Context) error {
+	if m.Filename == "" {
+		return nil
+	}
+
+	// Save map to filesystem
+	f, err := os.Create(m.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	en := json.NewEncoder(f)
+	en.SetIndent("", "\t")
+	return en.Encode(m.Drafts)
+}
+
+func init() {
+	newMapStore := func(_descriptor string) (Store, error) {
+		return mapStore{
+			Mu:     &sync.Mutex{},
+			Drafts: make(map[string]Draft),
+		}, nil
+	}
+	RegisterStorageMethod("", newMapStore)
+	RegisterStorageMethod("map", newMapStore)
+	RegisterStorageMethod("memory", newMapStore)
+
+	newFSStore := func(fileName string) (Store, error) {
+		rv := mapStore{
+			Mu:       &sync.Mutex{},
+			Drafts:   make(map[string]Draft),
+			Filename: fileName,
+		}
+
+		f, err := os.Open(fileName)
+		if errors.Is(err, fs.ErrNotExist) {
+			return rv, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		err = dec.Decode(&rv.Drafts)
+		if rv.Drafts == nil {
+			rv.Drafts = make(map[string]Draft)
+		}
+		return rv, err
+	}
+	RegisterStorageMethod("fs", newFSStore)
+	RegisterStorageMethod("file", newFSStore)
+	RegisterStorageMethod("json", newFSStore)
+}
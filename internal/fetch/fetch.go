@@ -0,0 +1,398 @@
+// Package fetch retrieves files from the web on behalf of users. Because the
+// URLs come from users, a Fetcher makes sure it never connects to the
+// server's own internal network: every host name is resolved once, the
+// addresses are checked, and the connection is made to a checked address.
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// ErrBlocked is returned when a URL points to an address on an internal network
+var ErrBlocked = errors.New("destination is on an internal network")
+
+// ErrTooLarge is returned when a response exceeds the maximum size
+var ErrTooLarge = errors.New("response too large")
+
+// ErrTooManyRedirects is returned when a URL redirects more often than allowed
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// A Fetcher retrieves URLs, while keeping requests away from internal networks
+type Fetcher struct {
+	UserAgent string
+
+	// MaxSize limits the size of a single response, or 0 for no limit
+	MaxSize int64
+
+	// Timeout limits the duration of a request, including reading the body
+	Timeout time.Duration
+
+	// MaxRedirects is the number of redirects followed before giving up
+	MaxRedirects int
+
+	// Allow lists networks that may be fetched from, even if they are internal
+	Allow []*net.IPNet
+
+	// Resolver looks up host names. If nil, net.DefaultResolver is used.
+	Resolver *net.Resolver
+}
+
+// New returns a fetcher with sensible timeouts and limits
+func New(userAgent string, allow []*net.IPNet) *Fetcher {
+	return &Fetcher{
+		UserAgent:    userAgent,
+		MaxSize:      16 << 20,
+		Timeout:      30 * time.Second,
+		MaxRedirects: 5,
+		Allow:        allow,
+	}
+}
+
+// ParseNetworks parses a comma-separated list of networks in CIDR notation.
+// Single IP addresses are allowed too.
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	var rv []*net.IPNet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address '%s'", part)
+			}
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			rv = append(rv, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, n)
+	}
+	return rv, nil
+}
+
+// blockedNetworks are address ranges that are not reachable from the public
+// internet, or that should never be connected to
+var blockedNetworks []*net.IPNet
+
+func init() {
+	var err error
+	blockedNetworks, err = ParseNetworks("0.0.0.0/8, 10.0.0.0/8, 100.64.0.0/10, 127.0.0.0/8, 169.254.0.0/16, 172.16.0.0/12, 192.0.0.0/24, 192.168.0.0/16, 198.18.0.0/15, 224.0.0.0/4, 240.0.0.0/4, " +
+		"::/128, ::1/128, 64:ff9b::/96, 100::/64, fc00::/7, fe80::/10, ff00::/8")
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Allowed checks if an IP address may be connected to
+func (f *Fetcher) Allowed(ip net.IP) bool {
+	// IPv4-mapped IPv6 addresses are checked as IPv4 addresses
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range f.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialContext resolves a host name and connects to the first address that
+// is allowed. The address is never resolved again, so that a DNS record
+// cannot change between the check and the connection.
+func (f *Fetcher) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	resolver := f.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	lastErr := fmt.Errorf("%s: no addresses found", host)
+	for _, ip := range ips {
+		if !f.Allowed(ip.IP) {
+			lastErr = fmt.Errorf("%w: %s resolves to %s", ErrBlocked, host, ip.IP)
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Client returns an HTTP client that applies the fetcher's restrictions. It
+// can be used by other components that make requests to user-supplied URLs.
+func (f *Fetcher) Client() *http.Client {
+	return &http.Client{
+		Timeout: f.Timeout,
+		Transport: &http.Transport{
+			// Never use a proxy: it would connect to the destination on our behalf
+			Proxy:                 nil,
+			DialContext:           f.dialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 15 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > f.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unsupported URL scheme '%s'", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// A Result describes the outcome of a fetch
+type Result struct {
+	URL      string
+	FinalURL string
+
+	// RemoteAddr is the address that was actually connected to
+	RemoteAddr string
+
+	StatusCode int
+
+	// DeclaredType is the Content-Type header sent by the server, and
+	// ContentType the type that was sniffed from the contents
+	DeclaredType string
+	ContentType  string
+
+	Size     int64
+	Started  time.Time
+	Duration time.Duration
+	Error    string `json:",omitempty"`
+}
+
+// Origin describes a fetched file that was stored as an attachment. It should
+// be called after the response is closed, so that the result is final.
+func (r Result) Origin(name string) storage.AttachmentOrigin {
+	started := r.Started
+	rv := storage.AttachmentOrigin{
+		Name:        name,
+		Source:      storage.SourceProxied,
+		URL:         r.URL,
+		ContentType: r.ContentType,
+		Fetched:     &started,
+		Fetch: &storage.FetchResult{
+			Status:       r.StatusCode,
+			RemoteAddr:   r.RemoteAddr,
+			DeclaredType: r.DeclaredType,
+			Size:         r.Size,
+			Duration:     r.Duration,
+			Error:        r.Error,
+		},
+	}
+	if r.FinalURL != r.URL {
+		rv.FinalURL = r.FinalURL
+	}
+	return rv
+}
+
+// A Response is the body of a successful fetch. Its Result is completed as
+// the body is read, and is final once it is closed.
+type Response struct {
+	Result Result
+
+	body   io.ReadCloser
+	cancel context.CancelFunc
+	closed bool
+}
+
+// Get fetches a URL. Responses with a status other than 2xx are returned as
+// errors.
+func (f *Fetcher) Get(ctx context.Context, rawURL string) (*Response, error) {
+	res := Result{URL: rawURL, Started: time.Now()}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme '%s'", u.Scheme)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			res.RemoteAddr = info.Conn.RemoteAddr().String()
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+
+	// Every call gets its own connection, so don't keep it around afterwards
+	client := f.Client()
+	client.Transport.(*http.Transport).DisableKeepAlives = true
+
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.StatusCode = resp.StatusCode
+	res.FinalURL = resp.Request.URL.String()
+	res.DeclaredType = resp.Header.Get("Content-Type")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%s: unexpected status %s", u, resp.Status)
+	}
+	if f.MaxSize > 0 && resp.ContentLength > f.MaxSize {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%s: %w", u, ErrTooLarge)
+	}
+
+	var body io.ReadCloser = resp.Body
+	if f.MaxSize > 0 {
+		body = &limitedBody{resp.Body, f.MaxSize}
+	}
+
+	// Sniff the content type from the first bytes, and put them back in front
+	// of the rest of the body
+	head := make([]byte, 512)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		body.Close()
+		cancel()
+		return nil, err
+	}
+	head = head[:n]
+	res.ContentType = SniffContentType(res.DeclaredType, head)
+
+	return &Response{
+		Result: res,
+		body: struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), body), body},
+		cancel: cancel,
+	}, nil
+}
+
+func (r *Response) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.Result.Size += int64(n)
+	if err != nil && err != io.EOF && r.Result.Error == "" {
+		r.Result.Error = err.Error()
+	}
+	return n, err
+}
+
+// Close closes the response body and finalizes the result
+func (r *Response) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.Result.Duration = time.Since(r.Result.Started)
+	err := r.body.Close()
+	r.cancel()
+	return err
+}
+
+// Fetch retrieves a URL, and returns its sniffed content type and its body.
+// It can be used as a capture.FetchFunc. The body is a *Response, so the
+// result of the fetch is available once it has been read.
+func (f *Fetcher) Fetch(ctx context.Context, u *url.URL) (string, io.ReadCloser, error) {
+	resp, err := f.Get(ctx, u.String())
+	if err != nil {
+		return "", nil, err
+	}
+	return resp.Result.ContentType, resp, nil
+}
+
+// SniffContentType determines the content type of a file from its first
+// bytes. The declared type is used only where the contents can't tell the
+// difference, e.g. between CSS and other plain text.
+func SniffContentType(declared string, head []byte) string {
+	declared = strings.ToLower(strings.TrimSpace(strings.SplitN(declared, ";", 2)[0]))
+	sniffed := strings.SplitN(http.DetectContentType(head), ";", 2)[0]
+
+	switch sniffed {
+	case "text/plain", "text/xml", "text/html":
+		// Web pages that don't start with a tag, and XHTML pages
+		if sniffed != "text/html" && (declared == "text/html" || declared == "application/xhtml+xml") {
+			return declared
+		}
+		// An SVG image may sniff as XML, or even as HTML if it starts with a comment
+		if isSVG(head) && (sniffed != "text/html" || declared == "image/svg+xml") {
+			return "image/svg+xml"
+		}
+		if sniffed == "text/plain" && declared == "text/css" {
+			return declared
+		}
+	}
+	return sniffed
+}
+
+// isSVG checks if the first bytes of a file look like an SVG image
+func isSVG(head []byte) bool {
+	return bytes.Contains(bytes.ToLower(head), []byte("<svg"))
+}
+
+// limitedBody returns ErrTooLarge once more than a number of bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
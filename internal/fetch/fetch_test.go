@@ -0,0 +1,129 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	ctx := context.Background()
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			// Many servers don't know what they're serving
+			w.Header().Set("Content-Type", "application/octet-stream")
+			io.WriteString(w, png)
+		case "/large":
+			io.WriteString(w, strings.Repeat("x", 2048))
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := New("test", nil)
+	_, err := f.Get(ctx, srv.URL+"/image")
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("Fetching from the loopback address should be blocked; got error %v", err)
+	}
+
+	allow, err := ParseNetworks("127.0.0.1, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = New("test", allow)
+
+	resp, err := f.Get(ctx, srv.URL+"/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(resp)
+	resp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != png {
+		t.Errorf("Contents changed while sniffing: %q", contents)
+	}
+	if resp.Result.ContentType != "image/png" {
+		t.Errorf("Sniffed content type '%s'", resp.Result.ContentType)
+	}
+	if resp.Result.Size != int64(len(png)) || resp.Result.StatusCode != 200 || resp.Result.RemoteAddr == "" {
+		t.Errorf("Incomplete result: %+v", resp.Result)
+	}
+	origin := resp.Result.Origin("t0000000001.png")
+	if origin.Fetch == nil || origin.Fetch.Status != 200 || origin.Fetch.Size != int64(len(png)) || origin.Fetch.Duration <= 0 || origin.Fetch.RemoteAddr != resp.Result.RemoteAddr {
+		t.Errorf("The manifest entry should record the outcome of the fetch; got %+v", origin.Fetch)
+	}
+
+	f.MaxSize = 1024
+	resp, err = f.Get(ctx, srv.URL+"/large")
+	if err == nil {
+		_, err = io.ReadAll(resp)
+		resp.Close()
+	}
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge; got %v", err)
+	}
+
+	_, err = f.Get(ctx, srv.URL+"/loop")
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Expected ErrTooManyRedirects; got %v", err)
+	}
+
+	_, err = f.Get(ctx, srv.URL+"/missing")
+	if err == nil {
+		t.Errorf("Error statuses should be returned as errors")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	f := New("test", nil)
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "169.254.169.254", "192.168.1.1", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "0.0.0.0"} {
+		if f.Allowed(net.ParseIP(ip)) {
+			t.Errorf("Address %s should be blocked", ip)
+		}
+	}
+	for _, ip := range []string{"93.184.216.34", "2606:2800:220:1::1"} {
+		if !f.Allowed(net.ParseIP(ip)) {
+			t.Errorf("Address %s should be allowed", ip)
+		}
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		Declared string
+		Head     string
+		Expected string
+	}{
+		{"text/css; charset=utf-8", "body { color: red; }", "text/css"},
+		{"text/html", "body { color: red; }", "text/html"},
+		{"image/png", "body { color: red; }", "text/plain"},
+		{"text/plain", "<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"/>", "image/svg+xml"},
+		{"image/svg+xml", "<svg xmlns=\"http://www.w3.org/2000/svg\"/>", "image/svg+xml"},
+		{"image/svg+xml", "<!-- Generator --><svg/>", "image/svg+xml"},
+		{"text/css", "<html><script>alert(1)</script>", "text/html"},
+		{"image/png", "GIF89a", "image/gif"},
+		{"application/octet-stream", "wOF2\x00\x01\x00\x00", "font/woff2"},
+		{"text/html; charset=utf-8", "Welcome to <b>my</b> page", "text/html"},
+		{"application/xhtml+xml", "<?xml version=\"1.0\"?>\n<html xmlns=\"http://www.w3.org/1999/xhtml\"><body><svg/></body></html>", "application/xhtml+xml"},
+		{"text/html", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png"},
+	}
+	for _, tc := range tests {
+		ct := SniffContentType(tc.Declared, []byte(tc.Head))
+		if ct != tc.Expected {
+			t.Errorf("Content type for %q (declared %s) is '%s'; expected '%s'", tc.Head, tc.Declared, ct, tc.Expected)
+		}
+	}
+}
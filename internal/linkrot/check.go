@@ -0,0 +1,139 @@
+package linkrot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A Checker requests URLs to find out if they're still online
+type Checker struct {
+	Client    *http.Client
+	UserAgent string
+}
+
+// NewChecker returns a checker with a sensible timeout
+func NewChecker(userAgent string) Checker {
+	return Checker{
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		UserAgent: userAgent,
+	}
+}
+
+// Check requests a URL, first using HEAD and then, if that doesn't show the
+// page is alive, using GET. Redirects are followed; a URL that ends up at a
+// different page is considered redirected.
+func (c Checker) Check(ctx context.Context, rawURL string) Result {
+	res := Result{URL: rawURL, Checked: time.Now()}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		res.Status = StatusError
+		res.Error = "invalid URL"
+		return res
+	}
+
+	res = c.request(ctx, "HEAD", u, res)
+	if res.Status != StatusAlive && res.Status != StatusDNSFailure {
+		res = c.request(ctx, "GET", u, res)
+	}
+	return res
+}
+
+func (c Checker) request(ctx context.Context, method string, u *url.URL, res Result) Result {
+	res.StatusCode, res.Location, res.Error = 0, "", ""
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		res.Status, res.Error = StatusError, err.Error()
+		return res
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			res.Status = StatusDNSFailure
+		} else {
+			res.Status = StatusError
+		}
+		res.Error = err.Error()
+		return res
+	}
+	resp.Body.Close()
+
+	res.StatusCode = resp.StatusCode
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		res.Status = StatusGone
+	} else if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if final := resp.Request.URL; samePage(u, final) {
+			res.Status = StatusAlive
+		} else {
+			res.Status = StatusRedirected
+			res.Location = final.String()
+		}
+	} else {
+		res.Status = StatusError
+		res.Error = fmt.Sprintf("HTTP %s", resp.Status)
+	}
+	return res
+}
+
+// samePage checks if two URLs point to the same page, ignoring differences
+// that redirects commonly fix, such as http vs. https, a www. prefix, or a
+// trailing slash.
+func samePage(a, b *url.URL) bool {
+	host := func(u *url.URL) string {
+		return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	}
+	if host(a) != host(b) || a.RawQuery != b.RawQuery {
+		return false
+	}
+	return strings.TrimSuffix(a.EscapedPath(), "/") == strings.TrimSuffix(b.EscapedPath(), "/")
+}
+
+// Refresh checks all URLs whose most recent result is older than maxAge, at
+// most limit per call, and forgets the results for URLs no longer in the list.
+// It returns the number of URLs checked.
+func Refresh(ctx context.Context, st Store, c Checker, urls []string, maxAge time.Duration, limit int) (int, error) {
+	err := st.Forget(ctx, urls)
+	if err != nil {
+		return 0, err
+	}
+	known, err := st.GetStatuses(ctx, urls)
+	if err != nil {
+		return 0, err
+	}
+
+	checked := 0
+	for _, u := range urls {
+		if res, ok := known[u]; ok && time.Since(res.Checked) < maxAge {
+			continue
+		}
+		if checked >= limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return checked, err
+		}
+
+		_, err := st.SetStatus(ctx, c.Check(ctx, u))
+		if err != nil {
+			return checked, err
+		}
+		checked++
+	}
+	return checked, nil
+}
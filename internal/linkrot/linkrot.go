@@ -0,0 +1,125 @@
+// Package linkrot checks if the original pages of captured documents are
+// still online.
+package linkrot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Status describes whether a URL is still online
+type Status string
+
+const (
+	// StatusAlive means the page was found at its original URL
+	StatusAlive Status = "alive"
+	// StatusRedirected means the URL now redirects to a different page
+	StatusRedirected Status = "redirected"
+	// StatusGone means the server responded with 404 Not Found or 410 Gone
+	StatusGone Status = "gone"
+	// StatusDNSFailure means the host name no longer resolves
+	StatusDNSFailure Status = "dns-failure"
+	// StatusError means the URL could not be checked for another reason,
+	// such as a timeout or a server error
+	StatusError Status = "error"
+)
+
+// A single missing page may be a temporary glitch, so a URL is only
+// considered dead after this many consecutive checks found it missing, spread
+// over at least DeadAfter
+const (
+	DeadAfterChecks int           = 3
+	DeadAfter       time.Duration = 24 * time.Hour
+)
+
+// A Result is the outcome of checking a URL
+type Result struct {
+	URL        string
+	Status     Status
+	StatusCode int    `json:",omitempty"`
+	Location   string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+
+	Checked time.Time
+	// Since is the first check that found the current status
+	Since time.Time
+	// LastAlive is the last time the URL was found to be alive
+	LastAlive time.Time `json:",omitempty"`
+
+	// Failures is the number of consecutive checks that found the page
+	// missing, and FailingSince the first of those checks
+	Failures     int       `json:",omitempty"`
+	FailingSince time.Time `json:",omitempty"`
+}
+
+// Missing returns true if this check found that the page does not exist
+func (r Result) Missing() bool {
+	return r.Status == StatusGone || r.Status == StatusDNSFailure
+}
+
+// Dead returns true if the original page no longer exists, i.e. it has been
+// missing for several consecutive checks
+func (r Result) Dead() bool {
+	return r.Missing() && r.Failures >= DeadAfterChecks && r.Checked.Sub(r.FailingSince) >= DeadAfter
+}
+
+// Describe returns a short human-readable description of the status
+func (r Result) Describe() string {
+	switch r.Status {
+	case StatusAlive:
+		return "online"
+	case StatusRedirected:
+		return "redirects to " + r.Location
+	case StatusGone:
+		return fmt.Sprintf("gone (HTTP %d)", r.StatusCode)
+	case StatusDNSFailure:
+		return "host not found"
+	case StatusError:
+		return "unreachable: " + r.Error
+	}
+	return "not checked"
+}
+
+type Store interface {
+	// GetStatuses returns the most recent results for a list of URLs. URLs
+	// that have not been checked yet are left out.
+	GetStatuses(context.Context, []string) (map[string]Result, error)
+
+	// SetStatus stores a new result. It keeps track of when the URL was
+	// last alive, since when it has had its current status, and how many
+	// consecutive checks found it missing.
+	SetStatus(context.Context, Result) (Result, error)
+
+	// Forget removes the results for all URLs not in the list
+	Forget(context.Context, []string) error
+}
+
+type StorageMethod func(string) (Store, error)
+
+var allStorageMethods map[string]StorageMethod
+
+func RegisterStorageMethod(name string, f StorageMethod) {
+	if allStorageMethods == nil {
+		allStorageMethods = make(map[string]StorageMethod)
+	}
+	allStorageMethods[name] = f
+}
+
+func GetStore(descriptor string) (Store, error) {
+	i := strings.IndexRune(descriptor, ':')
+	name := ""
+	if i >= 0 {
+		name = descriptor[:i]
+		descriptor = descriptor[i+1:]
+	}
+	if allStorageMethods == nil {
+		return nil, fmt.Errorf("no storage backends have been initialized")
+	}
+
+	if f, ok := allStorageMethods[name]; ok {
+		return f(descriptor)
+	}
+	return nil, fmt.Errorf("storage backend '%s' not registered", name)
+}
@@ -0,0 +1,117 @@
+package linkrot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/alive", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	mux.Handle("/moved", http.RedirectHandler("/elsewhere", http.StatusMovedPermanently))
+	mux.HandleFunc("/elsewhere", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Handle("/dir", http.RedirectHandler("/dir/", http.StatusMovedPermanently))
+	mux.HandleFunc("/dir/", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cases := []struct {
+		Path   string
+		Status Status
+	}{
+		{"/alive", StatusAlive},
+		{"/gone", StatusGone},
+		{"/missing", StatusGone},
+		{"/moved", StatusRedirected},
+		{"/dir", StatusAlive},
+		{"/no-head", StatusAlive},
+		{"/broken", StatusError},
+	}
+
+	c := NewChecker("doc-hoarder-test")
+	for _, cc := range cases {
+		res := c.Check(ctx, srv.URL+cc.Path)
+		if res.Status != cc.Status {
+			t.Errorf("%s: expected status %s; got %+v", cc.Path, cc.Status, res)
+		}
+		if cc.Status == StatusRedirected && res.Location != srv.URL+"/elsewhere" {
+			t.Errorf("%s: unexpected redirect location '%s'", cc.Path, res.Location)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	st, err := GetStore("memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	const u = "https://example.org/"
+	for i, s := range []Status{StatusAlive, StatusGone, StatusGone} {
+		_, err := st.SetStatus(ctx, Result{URL: u, Status: s, Checked: t0.Add(time.Duration(i) * time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := st.GetStatuses(ctx, []string{u, "https://example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("Expected one result; got %v", res)
+	}
+	if r := res[u]; r.Dead() || !r.Since.Equal(t0.Add(time.Hour)) || !r.LastAlive.Equal(t0) || r.Failures != 2 {
+		t.Errorf("Unexpected result %+v", r)
+	}
+
+	// Only a page that stays missing for a while is dead
+	for _, d := range []time.Duration{12 * time.Hour, 25 * time.Hour} {
+		r, err := st.SetStatus(ctx, Result{URL: u, Status: StatusDNSFailure, Checked: t0.Add(d)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dead := d > DeadAfter; r.Dead() != dead || r.Failures < DeadAfterChecks || !r.FailingSince.Equal(t0.Add(time.Hour)) {
+			t.Errorf("After %s: expected dead=%v; got %+v", d, dead, r)
+		}
+	}
+
+	// A page that comes back starts over
+	r, err := st.SetStatus(ctx, Result{URL: u, Status: StatusAlive, Checked: t0.Add(26 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = st.SetStatus(ctx, Result{URL: u, Status: StatusGone, Checked: t0.Add(50 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Dead() || r.Failures != 1 {
+		t.Errorf("A single failure after the page came back should not be dead; got %+v", r)
+	}
+
+	err = st.Forget(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := st.GetStatuses(ctx, []string{u}); len(res) != 0 {
+		t.Errorf("Expected all results to be forgotten; got %v", res)
+	}
+}
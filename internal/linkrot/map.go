@@ -0,0 +1,124 @@
+package linkrot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+type mapStore struct {
+	Mu       *sync.Mutex
+	Results  map[string]Result
+	Filename string
+}
+
+func (m mapStore) GetStatuses(ctx context.Context, urls []string) (map[string]Result, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv := make(map[string]Result)
+	for _, u := range urls {
+		if res, ok := m.Results[u]; ok {
+			rv[u] = res
+		}
+	}
+	return rv, nil
+}
+
+func (m mapStore) SetStatus(ctx context.Context, res Result) (Result, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	prev, ok := m.Results[res.URL]
+	res.Since = res.Checked
+	if ok && prev.Status == res.Status {
+		res.Since = prev.Since
+	}
+	if res.Status == StatusAlive {
+		res.LastAlive = res.Checked
+	} else if ok {
+		res.LastAlive = prev.LastAlive
+	}
+	if res.Missing() {
+		res.Failures, res.FailingSince = 1, res.Checked
+		if ok && prev.Missing() {
+			res.Failures, res.FailingSince = prev.Failures+1, prev.FailingSince
+		}
+	}
+
+	m.Results[res.URL] = res
+	return res, m.saveContents(ctx)
+}
+
+func (m mapStore) Forget(ctx context.Context, urls []string) error {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	keep := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		keep[u] = true
+	}
+	for u := range m.Results {
+		if !keep[u] {
+			delete(m.Results, u)
+		}
+	}
+	return m.saveContents(ctx)
+}
+
+func (m mapStore) saveContents(ctx context.Context) error {
+	if m.Filename == "" {
+		return nil
+	}
+
+	// Save map to filesystem
+	f, err := os.Create(m.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	en := json.NewEncoder(f)
+	en.SetIndent("", "\t")
+	return en.Encode(m.Results)
+}
+
+func init() {
+	newMapStore := func(_descriptor string) (Store, error) {
+		return mapStore{
+			Mu:      &sync.Mutex{},
+			Results: make(map[string]Result),
+		}, nil
+	}
+	RegisterStorageMethod("", newMapStore)
+	RegisterStorageMethod("map", newMapStore)
+	RegisterStorageMethod("memory", newMapStore)
+
+	newFSStore := func(fileName string) (Store, error) {
+		rv := mapStore{
+			Mu:       &sync.Mutex{},
+			Results:  make(map[string]Result),
+			Filename: fileName,
+		}
+
+		f, err := os.Open(fileName)
+		if errors.Is(err, fs.ErrNotExist) {
+			return rv, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		err = dec.Decode(&rv.Results)
+		if rv.Results == nil {
+			rv.Results = make(map[string]Result)
+		}
+		return rv, err
+	}
+	RegisterStorageMethod("fs", newFSStore)
+	RegisterStorageMethod("file", newFSStore)
+	RegisterStorageMethod("json", newFSStore)
+}
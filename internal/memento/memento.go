@@ -0,0 +1,149 @@
+// Package memento implements the parts of the Memento protocol (RFC 7089)
+// that allow standard web archive clients to find captured documents.
+package memento
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A Memento is a single capture of an original resource
+type Memento struct {
+	URL      string
+	Datetime time.Time
+}
+
+// ParseAcceptDatetime parses the Accept-Datetime header of a request. If no
+// header is present, the current time is returned.
+func ParseAcceptDatetime(r *http.Request) (time.Time, error) {
+	h := r.Header.Get("Accept-Datetime")
+	if h == "" {
+		return time.Now(), nil
+	}
+	return http.ParseTime(h)
+}
+
+// FormatDatetime formats a time in the format required by the Memento protocol
+func FormatDatetime(t time.Time) string {
+	return t.UTC().Format(http.TimeFormat)
+}
+
+// Closest finds the memento closest to the requested time. The mementos
+// should be sorted in chronological order.
+func Closest(mementos []Memento, t time.Time) (Memento, bool) {
+	if len(mementos) == 0 {
+		return Memento{}, false
+	}
+
+	rv := mementos[0]
+	best := absDuration(t.Sub(rv.Datetime))
+	for _, m := range mementos[1:] {
+		d := absDuration(t.Sub(m.Datetime))
+		if d <= best {
+			rv, best = m, d
+		}
+	}
+	return rv, true
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// A Link is a single entry in a Link header or a TimeMap
+type Link struct {
+	URL         string
+	Rel         string
+	Type        string
+	Datetime    time.Time
+	From, Until time.Time
+}
+
+func (l Link) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%s>; rel=\"%s\"", l.URL, l.Rel)
+	if l.Type != "" {
+		fmt.Fprintf(&b, "; type=\"%s\"", l.Type)
+	}
+	if !l.Datetime.IsZero() {
+		fmt.Fprintf(&b, "; datetime=\"%s\"", FormatDatetime(l.Datetime))
+	}
+	if !l.From.IsZero() {
+		fmt.Fprintf(&b, "; from=\"%s\"", FormatDatetime(l.From))
+	}
+	if !l.Until.IsZero() {
+		fmt.Fprintf(&b, "; until=\"%s\"", FormatDatetime(l.Until))
+	}
+	return b.String()
+}
+
+// LinkHeader formats a list of links for use in a HTTP Link header
+func LinkHeader(links ...Link) string {
+	parts := make([]string, len(links))
+	for i, l := range links {
+		parts[i] = l.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// TimeMap generates a TimeMap in application/link-format for an original
+// resource. The mementos should be sorted in chronological order.
+func TimeMap(original, timegate, timemap string, mementos []Memento) []byte {
+	links := []Link{
+		{URL: original, Rel: "original"},
+		{URL: timegate, Rel: "timegate"},
+	}
+
+	self := Link{URL: timemap, Rel: "self", Type: "application/link-format"}
+	if len(mementos) > 0 {
+		self.From = mementos[0].Datetime
+		self.Until = mementos[len(mementos)-1].Datetime
+	}
+	links = append(links, self)
+
+	for i, m := range mementos {
+		rel := "memento"
+		if i == 0 && i == len(mementos)-1 {
+			rel = "first last memento"
+		} else if i == 0 {
+			rel = "first memento"
+		} else if i == len(mementos)-1 {
+			rel = "last memento"
+		}
+		links = append(links, Link{URL: m.URL, Rel: rel, Datetime: m.Datetime})
+	}
+
+	parts := make([]string, len(links))
+	for i, l := range links {
+		parts[i] = l.String()
+	}
+	return []byte(strings.Join(parts, ",\n") + "\n")
+}
+
+// OriginalURL extracts the original URL from a request path like
+// /timegate/http://example.org/. Since path cleaning can collapse the double
+// slash after the scheme, it is restored here.
+func OriginalURL(r *http.Request, prefix string) string {
+	p := r.URL.Path
+	if !strings.HasPrefix(p, prefix) {
+		return ""
+	}
+	p = p[len(prefix):]
+
+	for _, scheme := range []string{"http:", "https:"} {
+		if strings.HasPrefix(p, scheme) {
+			p = scheme + "//" + strings.TrimLeft(p[len(scheme):], "/")
+			break
+		}
+	}
+
+	if r.URL.RawQuery != "" {
+		p += "?" + r.URL.RawQuery
+	}
+	return p
+}
@@ -0,0 +1,62 @@
+package memento
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOriginalURL(t *testing.T) {
+	cases := []struct {
+		Path     string
+		Expected string
+	}{
+		{"/timegate/http://example.org/", "http://example.org/"},
+		{"/timegate/http:/example.org/", "http://example.org/"},
+		{"/timegate/https:/example.org/foo?bar=baz", "https://example.org/foo?bar=baz"},
+		{"/timemap/link/https://example.org/", ""},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.Path, nil)
+		u := OriginalURL(r, "/timegate/")
+		if u != c.Expected {
+			t.Errorf("Path %s: expected '%s'; got '%s'", c.Path, c.Expected, u)
+		}
+	}
+}
+
+func TestTimeGate(t *testing.T) {
+	t0 := time.Date(2023, 1, 2, 17, 7, 12, 0, time.UTC)
+	mementos := []Memento{
+		{"a", t0},
+		{"b", t0.Add(24 * time.Hour)},
+		{"c", t0.Add(72 * time.Hour)},
+	}
+
+	cases := []struct {
+		Datetime time.Time
+		Expected string
+	}{
+		{t0.Add(-1000 * time.Hour), "a"},
+		{t0.Add(13 * time.Hour), "b"},
+		{t0.Add(47 * time.Hour), "b"},
+		{t0.Add(49 * time.Hour), "c"},
+		{t0.Add(1000 * time.Hour), "c"},
+	}
+	for _, c := range cases {
+		m, ok := Closest(mementos, c.Datetime)
+		if !ok || m.URL != c.Expected {
+			t.Errorf("At %s: expected memento %s; got %s", c.Datetime, c.Expected, m.URL)
+		}
+	}
+
+	tm := string(TimeMap("http://example.org/", "tg", "tm", mementos))
+	if !strings.Contains(tm, "<a>; rel=\"first memento\"; datetime=\"Mon, 02 Jan 2023 17:07:12 GMT\"") {
+		t.Errorf("TimeMap does not list the first memento:\n%s", tm)
+	}
+	if !strings.Contains(tm, "<c>; rel=\"last memento\"") {
+		t.Errorf("TimeMap does not list the last memento:\n%s", tm)
+	}
+}
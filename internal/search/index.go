@@ -0,0 +1,400 @@
+// Package search maintains a full-text index of all documents in a store
+package search
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/doctext"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// An IndexMethod creates an empty index. It is loaded by GetIndex.
+type IndexMethod func(string, storage.DocStore) (*Index, error)
+
+var allIndexMethods map[string]IndexMethod
+
+func RegisterIndexMethod(name string, f IndexMethod) {
+	if allIndexMethods == nil {
+		allIndexMethods = make(map[string]IndexMethod)
+	}
+	allIndexMethods[name] = f
+}
+
+// ErrNotPersistent is returned when rebuilding an index that is not stored in a file
+var ErrNotPersistent = errors.New("this search index is not stored in a file")
+
+// GetIndex opens a search index, and catches up with any documents that
+// changed while it was closed
+func GetIndex(descriptor string, store storage.DocStore) (*Index, error) {
+	idx, err := openIndex(descriptor, store)
+	if err != nil {
+		return nil, err
+	}
+
+	err = idx.load(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if cn, ok := store.(storage.ChangeNotifier); ok {
+		cn.OnChange(idx.documentChanged)
+	} else {
+		log.Printf("warning: document store %T does not report changes; the search index will go stale", store)
+	}
+
+	return idx, nil
+}
+
+// RebuildIndex re-indexes all documents in a store from scratch, and writes
+// the result to the index's file
+func RebuildIndex(ctx context.Context, descriptor string, store storage.DocStore) error {
+	idx, err := openIndex(descriptor, store)
+	if err != nil {
+		return err
+	}
+	if idx.Filename == "" {
+		return ErrNotPersistent
+	}
+	return idx.Rebuild(ctx)
+}
+
+func openIndex(descriptor string, store storage.DocStore) (*Index, error) {
+	i := strings.IndexRune(descriptor, ':')
+	name := ""
+	if i >= 0 {
+		name = descriptor[:i]
+		descriptor = descriptor[i+1:]
+	}
+	if allIndexMethods == nil {
+		return nil, fmt.Errorf("no search indexes have been initialized")
+	}
+
+	if f, ok := allIndexMethods[name]; ok {
+		return f(descriptor, store)
+	}
+	return nil, fmt.Errorf("search index '%s' not registered", name)
+}
+
+func init() {
+	newIndex := func(fileName string, store storage.DocStore) (*Index, error) {
+		return &Index{
+			store:    store,
+			Filename: fileName,
+		}, nil
+	}
+	RegisterIndexMethod("", func(_ string, store storage.DocStore) (*Index, error) {
+		return newIndex("", store)
+	})
+	RegisterIndexMethod("memory", func(_ string, store storage.DocStore) (*Index, error) {
+		return newIndex("", store)
+	})
+	RegisterIndexMethod("fs", newIndex)
+	RegisterIndexMethod("file", newIndex)
+}
+
+// An Index is an inverted index of the text and metadata of documents
+type Index struct {
+	store    storage.DocStore
+	Filename string
+
+	mu       sync.RWMutex
+	docs     map[string]indexedDoc
+	postings map[string]map[string]int
+	totalLen int
+
+	// saveMu makes sure only one copy of the index is written at a time, and
+	// protects saveTimer
+	saveMu    sync.Mutex
+	saveTimer *time.Timer
+}
+
+type indexedDoc struct {
+	// Version is the version of the document that was indexed
+	Version string
+
+	Meta   storage.DocumentMeta
+	Text   string
+	Length int
+}
+
+// saveDelay is the time changes are collected before the index file is
+// written again. Changes that are lost in a crash are picked up the next time
+// the index is loaded.
+const saveDelay = 30 * time.Second
+
+// Field weights: a match in the title counts for more than a match in the body text
+const (
+	weightTitle  int = 5
+	weightAuthor int = 3
+	weightURL    int = 2
+	weightText   int = 1
+)
+
+func (idx *Index) load(ctx context.Context) error {
+	if idx.Filename == "" {
+		return idx.Rebuild(ctx)
+	}
+
+	f, err := os.Open(idx.Filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx.Rebuild(ctx)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	docs := make(map[string]indexedDoc)
+	dec := gob.NewDecoder(f)
+	err = dec.Decode(&docs)
+	if err != nil {
+		return err
+	}
+
+	// Documents may have been added, modified or deleted while the index was
+	// closed, e.g. by one of the command line subcommands
+	docids, err := idx.store.DocumentIDs(ctx)
+	if err != nil {
+		return err
+	}
+	changed := false
+	present := make(map[string]bool, len(docids))
+	for _, docid := range docids {
+		present[docid] = true
+		if doc, ok := docs[docid]; ok && doc.Version == storage.DocumentVersion(ctx, idx.store, docid) {
+			continue
+		}
+		if doc, err := idx.readDocument(ctx, docid); err == nil {
+			docs[docid] = doc
+			changed = true
+		} else if _, ok := docs[docid]; ok {
+			delete(docs, docid)
+			changed = true
+		}
+	}
+	for docid := range docs {
+		if !present[docid] {
+			delete(docs, docid)
+			changed = true
+		}
+	}
+
+	idx.replace(docs)
+	if changed {
+		return idx.save()
+	}
+	return nil
+}
+
+// Rebuild discards the entire index, and re-indexes all documents in the store
+func (idx *Index) Rebuild(ctx context.Context) error {
+	docids, err := idx.store.DocumentIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	docs := make(map[string]indexedDoc, len(docids))
+	for _, docid := range docids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		doc, err := idx.readDocument(ctx, docid)
+		if err != nil {
+			continue
+		}
+		docs[docid] = doc
+	}
+
+	idx.replace(docs)
+	return idx.save()
+}
+
+// replace swaps the contents of the index for a new set of documents
+func (idx *Index) replace(docs map[string]indexedDoc) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.docs = make(map[string]indexedDoc, len(docs))
+	idx.postings = make(map[string]map[string]int)
+	idx.totalLen = 0
+	for docid, doc := range docs {
+		idx.add(docid, doc)
+	}
+}
+
+func (idx *Index) readDocument(ctx context.Context, docid string) (indexedDoc, error) {
+	var rv indexedDoc
+
+	// Get the version first, so that changes made while the document is read
+	// are picked up later
+	rv.Version = storage.DocumentVersion(ctx, idx.store, docid)
+
+	trns, err := idx.store.GetDocument(docid)
+	if err != nil {
+		return rv, err
+	}
+	defer trns.Rollback()
+
+	rv.Meta, err = storage.ReadMeta(ctx, trns)
+	if err != nil {
+		return rv, err
+	}
+
+	f, err := trns.ReadRootFile(ctx, "document.bin")
+	if err != nil {
+		// Index the metadata, even if there's no document body
+		return rv, nil
+	}
+	defer f.Close()
+
+	rv.Text, err = doctext.Extract(f)
+	if err != nil {
+		log.Printf("error extracting text from document g%s: %v", docid, err)
+	}
+	return rv, nil
+}
+
+// add adds a document to the inverted index. The caller should hold the write lock.
+func (idx *Index) add(docid string, doc indexedDoc) {
+	freqs := make(map[string]int)
+	doc.Length = 0
+	addTerms := func(s string, weight int) {
+		for _, term := range tokenize(s) {
+			freqs[term] += weight
+			doc.Length++
+		}
+	}
+	addTerms(doc.Meta.Title, weightTitle)
+	addTerms(doc.Meta.Author, weightAuthor)
+	addTerms(doc.Meta.URL, weightURL)
+	addTerms(doc.Text, weightText)
+
+	for term, n := range freqs {
+		p, ok := idx.postings[term]
+		if !ok {
+			p = make(map[string]int)
+			idx.postings[term] = p
+		}
+		p[docid] = n
+	}
+
+	idx.docs[docid] = doc
+	idx.totalLen += doc.Length
+}
+
+// remove removes a document from the inverted index. The caller should hold the write lock.
+func (idx *Index) remove(docid string) {
+	doc, ok := idx.docs[docid]
+	if !ok {
+		return
+	}
+
+	for _, s := range []string{doc.Meta.Title, doc.Meta.Author, doc.Meta.URL, doc.Text} {
+		for _, term := range tokenize(s) {
+			if p, ok := idx.postings[term]; ok {
+				delete(p, docid)
+				if len(p) == 0 {
+					delete(idx.postings, term)
+				}
+			}
+		}
+	}
+
+	idx.totalLen -= doc.Length
+	delete(idx.docs, docid)
+}
+
+// save writes the index to disk
+func (idx *Index) save() error {
+	if idx.Filename == "" {
+		return nil
+	}
+
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+	if idx.saveTimer != nil {
+		idx.saveTimer.Stop()
+		idx.saveTimer = nil
+	}
+
+	// Only hold the lock while copying, so that changes aren't held up by
+	// writing the file. The indexed documents themselves are never modified.
+	idx.mu.RLock()
+	docs := make(map[string]indexedDoc, len(idx.docs))
+	for docid, doc := range idx.docs {
+		docs[docid] = doc
+	}
+	idx.mu.RUnlock()
+
+	tmpName := path.Join(path.Dir(idx.Filename), "."+path.Base(idx.Filename)+".tmp")
+	g, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(g)
+	err = enc.Encode(docs)
+	if err != nil {
+		g.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	err = g.Close()
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, idx.Filename)
+}
+
+// saveLater writes the index to disk after a while, so that a series of
+// changes results in a single write
+func (idx *Index) saveLater() {
+	if idx.Filename == "" {
+		return
+	}
+
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+	if idx.saveTimer != nil {
+		return
+	}
+	idx.saveTimer = time.AfterFunc(saveDelay, func() {
+		if err := idx.save(); err != nil {
+			log.Printf("error saving search index: %v", err)
+		}
+	})
+}
+
+// Close writes any pending changes to disk
+func (idx *Index) Close() error {
+	idx.saveMu.Lock()
+	pending := idx.saveTimer != nil
+	idx.saveMu.Unlock()
+	if !pending {
+		return nil
+	}
+	return idx.save()
+}
+
+func (idx *Index) documentChanged(ctx context.Context, docid string) {
+	doc, err := idx.readDocument(ctx, docid)
+
+	idx.mu.Lock()
+	idx.remove(docid)
+	if err == nil {
+		idx.add(docid, doc)
+	}
+	idx.mu.Unlock()
+
+	idx.saveLater()
+}
@@ -0,0 +1,248 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// Result contains one page of search results
+type Result struct {
+	Total int   `json:"total"`
+	Hits  []Hit `json:"hits"`
+}
+
+// A Hit is a single document matching the search query
+type Hit struct {
+	DocumentID  string     `json:"id"`
+	Title       string     `json:"title"`
+	Author      string     `json:"author,omitempty"`
+	URL         string     `json:"url,omitempty"`
+	CaptureDate time.Time  `json:"capture_date"`
+	Score       float64    `json:"score"`
+	Snippet     []Fragment `json:"snippet"`
+}
+
+// A Fragment is a part of a snippet. Fragments that matched the query are highlighted.
+type Fragment struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// BM25 parameters
+const (
+	bm25k1 float64 = 1.2
+	bm25b  float64 = 0.75
+)
+
+const snippetLength int = 240
+
+// Search finds all documents visible to this user that contain all terms in the query
+func (idx *Index) Search(ctx context.Context, query string, user storage.Principal, limit storage.Limit) (Result, error) {
+	var rv Result
+
+	terms := uniq(tokenize(query))
+	if len(terms) == 0 {
+		return rv, nil
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// Start with the rarest term, and intersect with the rest
+	sort.Slice(terms, func(i, j int) bool {
+		return len(idx.postings[terms[i]]) < len(idx.postings[terms[j]])
+	})
+
+	candidates := []string{}
+	for docid := range idx.postings[terms[0]] {
+		candidates = append(candidates, docid)
+	}
+
+	N := float64(len(idx.docs))
+	avgLen := 1.0
+	if len(idx.docs) > 0 && idx.totalLen > 0 {
+		avgLen = float64(idx.totalLen) / N
+	}
+
+	type scored struct {
+		docid string
+		score float64
+	}
+	matches := []scored{}
+	for _, docid := range candidates {
+		if err := ctx.Err(); err != nil {
+			return rv, err
+		}
+
+		doc := idx.docs[docid]
+		if doc.Meta.Trashed() || !doc.Meta.CanRead(user) {
+			continue
+		}
+
+		score := 0.0
+		allTerms := true
+		for _, term := range terms {
+			p := idx.postings[term]
+			tf, ok := p[docid]
+			if !ok {
+				allTerms = false
+				break
+			}
+			n := float64(len(p))
+			idf := math.Log(1 + (N-n+0.5)/(n+0.5))
+			norm := 1 - bm25b + bm25b*float64(doc.Length)/avgLen
+			score += idf * (float64(tf) * (bm25k1 + 1)) / (float64(tf) + bm25k1*norm)
+		}
+		if allTerms {
+			matches = append(matches, scored{docid, score})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score == matches[j].score {
+			return matches[i].docid < matches[j].docid
+		}
+		return matches[i].score > matches[j].score
+	})
+
+	rv.Total = len(matches)
+	if limit.Offset >= len(matches) {
+		return rv, nil
+	}
+	matches = matches[limit.Offset:]
+	if limit.Limit > 0 && len(matches) > limit.Limit {
+		matches = matches[:limit.Limit]
+	}
+
+	termSet := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		termSet[term] = true
+	}
+
+	rv.Hits = make([]Hit, len(matches))
+	for i, m := range matches {
+		doc := idx.docs[m.docid]
+		rv.Hits[i] = Hit{
+			DocumentID:  m.docid,
+			Title:       doc.Meta.Title,
+			Author:      doc.Meta.Author,
+			URL:         doc.Meta.URL,
+			CaptureDate: doc.Meta.CaptureDate,
+			Score:       m.score,
+			Snippet:     snippet(doc.Text, termSet),
+		}
+	}
+
+	return rv, nil
+}
+
+type word struct {
+	Start, End int
+	Term       string
+}
+
+// words splits a text into words, and keeps track of their position in the original text
+func words(s string) []word {
+	rv := []word{}
+	start := -1
+	for i, c := range s {
+		isWord := unicode.IsLetter(c) || unicode.IsNumber(c)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			rv = appendWord(rv, s, start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		rv = appendWord(rv, s, start, len(s))
+	}
+	return rv
+}
+
+func appendWord(rv []word, s string, start, end int) []word {
+	if utf8.RuneCountInString(s[start:end]) < 2 {
+		return rv
+	}
+	return append(rv, word{start, end, strings.ToLower(s[start:end])})
+}
+
+func tokenize(s string) []string {
+	ws := words(s)
+	rv := make([]string, len(ws))
+	for i, w := range ws {
+		rv[i] = w.Term
+	}
+	return rv
+}
+
+func uniq(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	rv := make([]string, 0, len(terms))
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			rv = append(rv, t)
+		}
+	}
+	return rv
+}
+
+// snippet extracts a part of the text surrounding the first match
+func snippet(text string, terms map[string]bool) []Fragment {
+	ws := words(text)
+	if len(ws) == 0 {
+		return nil
+	}
+
+	first := 0
+	for i, w := range ws {
+		if terms[w.Term] {
+			first = i
+			break
+		}
+	}
+
+	// Include a few words of context before the first match
+	startWord := first - 8
+	if startWord < 0 {
+		startWord = 0
+	}
+	start := ws[startWord].Start
+
+	// End the snippet on a word boundary
+	endWord := startWord
+	for endWord+1 < len(ws) && ws[endWord+1].End-start <= snippetLength {
+		endWord++
+	}
+	end := ws[endWord].End
+
+	rv := []Fragment{}
+	if startWord > 0 {
+		rv = append(rv, Fragment{Text: "…"})
+	}
+	pos := start
+	for _, w := range ws[startWord : endWord+1] {
+		if terms[w.Term] {
+			if w.Start > pos {
+				rv = append(rv, Fragment{Text: text[pos:w.Start]})
+			}
+			rv = append(rv, Fragment{Text: text[w.Start:w.End], Match: true})
+			pos = w.End
+		}
+	}
+	if end > pos {
+		rv = append(rv, Fragment{Text: text[pos:end]})
+	}
+	if endWord+1 < len(ws) {
+		rv = append(rv, Fragment{Text: "…"})
+	}
+	return rv
+}
@@ -0,0 +1,147 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+
+	storeDir := t.TempDir()
+	store, err := storage.GetDocStore("fs:" + storeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addDocument := func(title, owner string, public bool, body string) string {
+		id, err := store.NewDocumentID(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trns, err := store.GetDocument(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := storage.DocumentMeta{Title: title, Status: storage.StatusStatic}
+		meta.Permissions.Owner = owner
+		meta.Permissions.Public = public
+		err = storage.WriteMeta(ctx, trns, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := trns.WriteRootFile(ctx, "document.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(g, "<!DOCTYPE html>\n<html><head><title>%s</title><style>p { color: orange; }</style></head><body>%s</body></html>", title, body)
+		g.Close()
+		err = trns.Commit(ctx, "test document")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	indexFile := path.Join(t.TempDir(), "search.idx")
+	idx, err := GetIndex("file:"+indexFile, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apples := addDocument("Apples", "alice", true, "<p>Apples are a kind of fruit.</p><p>They are not oranges.</p>")
+	pears := addDocument("Pears", "bob", false, "<p>Pears are also fruit, and they are shaped like a pear.</p>")
+
+	res, err := idx.Search(ctx, "fruit", storage.Principal{UserID: "alice"}, storage.Limit{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Total != 1 || res.Hits[0].DocumentID != apples {
+		t.Errorf("Expected to find only public document %s; got %+v", apples, res)
+	}
+
+	res, err = idx.Search(ctx, "fruit", storage.Principal{UserID: "bob"}, storage.Limit{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Total != 2 {
+		t.Errorf("Expected to find two documents; got %+v", res)
+	}
+
+	res, err = idx.Search(ctx, "PEAR fruit", storage.Principal{UserID: "bob"}, storage.Limit{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Total != 1 || res.Hits[0].DocumentID != pears {
+		t.Errorf("Expected to find only document %s; got %+v", pears, res)
+	} else {
+		matches := 0
+		for _, frag := range res.Hits[0].Snippet {
+			if frag.Match {
+				matches++
+			}
+		}
+		if matches != 2 {
+			t.Errorf("Expected two highlighted words in the snippet; got %+v", res.Hits[0].Snippet)
+		}
+	}
+
+	res, err = idx.Search(ctx, "orange", storage.Principal{UserID: "alice"}, storage.Limit{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Total != 0 {
+		t.Errorf("Stylesheets should not be indexed; got %+v", res)
+	}
+
+	// Documents added or modified while the index is closed are indexed when
+	// it is opened again
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = storage.GetDocStore("fs:" + storeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quinces := addDocument("Quinces", "alice", false, "<p>Quinces are fruit too.</p>")
+	trns, err := store.GetDocument(apples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := trns.WriteRootFile(ctx, "document.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(g, "<!DOCTYPE html>\n<html><head><title>Apples</title></head><body><p>Apples grow on trees.</p></body></html>")
+	g.Close()
+	if err := trns.Commit(ctx, "edit document"); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err = GetIndex("file:"+indexFile, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = idx.Search(ctx, "quinces", storage.Principal{UserID: "alice"}, storage.Limit{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Total != 1 || res.Hits[0].DocumentID != quinces {
+		t.Errorf("Expected to find document %s added while the index was closed; got %+v", quinces, res)
+	}
+	res, err = idx.Search(ctx, "trees", storage.Principal{UserID: "alice"}, storage.Limit{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Total != 1 || res.Hits[0].DocumentID != apples {
+		t.Errorf("Expected to find document %s modified while the index was closed; got %+v", apples, res)
+	}
+	res, err = idx.Search(ctx, "oranges", storage.Principal{UserID: "alice"}, storage.Limit{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	} else if res.Total != 0 {
+		t.Errorf("Expected the old text of document %s to be gone; got %+v", apples, res)
+	}
+
+	if err := RebuildIndex(ctx, "", store); err != ErrNotPersistent {
+		t.Errorf("Rebuilding an index in memory should fail; got %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type mapStore struct {
+	Mu       *sync.Mutex
+	Contents *mapContents
+	Filename string
+}
+
+type mapContents struct {
+	Key   []byte
+	Links map[string]Link
+}
+
+func (m mapStore) NewLink(ctx context.Context, l Link) (Link, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	for i := 0; i < 32; i++ {
+		l.ID = NewLinkID()
+		if _, ok := m.Contents.Links[l.ID]; !ok {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return l, err
+		}
+	}
+	l.Created = time.Now()
+	l.Views = 0
+	l.Revoked = false
+
+	m.Contents.Links[l.ID] = l
+	return l, m.saveContents(ctx)
+}
+
+func (m mapStore) GetLink(ctx context.Context, id string) (Link, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv, ok := m.Contents.Links[id]
+	if !ok {
+		return rv, ErrNotPresent
+	}
+	return rv, nil
+}
+
+func (m mapStore) GetLinksForOwner(ctx context.Context, owner string) ([]Link, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv := []Link{}
+	for _, l := range m.Contents.Links {
+		if l.Owner == owner {
+			rv = append(rv, l)
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Created.After(rv[j].Created)
+	})
+	return rv, nil
+}
+
+func (m mapStore) RevokeLink(ctx context.Context, owner, id string) error {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	l, ok := m.Contents.Links[id]
+	if !ok || l.Owner != owner {
+		return ErrNotPresent
+	}
+	l.Revoked = true
+	m.Contents.Links[id] = l
+	return m.saveContents(ctx)
+}
+
+func (m mapStore) CountView(ctx context.Context, id string) (Link, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	l, ok := m.Contents.Links[id]
+	if !ok {
+		return l, ErrNotPresent
+	}
+	if !l.Active(time.Now()) {
+		return l, ErrInactive
+	}
+	l.Views++
+	m.Contents.Links[id] = l
+	return l, m.saveContents(ctx)
+}
+
+func (m mapStore) SigningKey(ctx context.Context) ([]byte, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	if len(m.Contents.Key) == 0 {
+		m.Contents.Key = make([]byte, 32)
+		if _, err := rand.Read(m.Contents.Key); err != nil {
+			m.Contents.Key = nil
+			return nil, err
+		}
+		if err := m.saveContents(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return m.Contents.Key, nil
+}
+
+func (m mapStore) Prune(ctx context.Context) error {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	now := time.Now()
+	for id, l := range m.Contents.Links {
+		// Links whose views are used up are kept until they expire, so that
+		// the attachments of their last view can still be loaded
+		if !l.Valid(now) {
+			delete(m.Contents.Links, id)
+		}
+	}
+	return m.saveContents(ctx)
+}
+
+func (m mapStore) saveContents(ctx context.Context) error {
+	if m.Filename == "" {
+		return nil
+	}
+
+	// Save map to filesystem
+	f, err := os.OpenFile(m.Filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	en := json.NewEncoder(f)
+	en.SetIndent("", "\t")
+	return en.Encode(m.Contents)
+}
+
+func init() {
+	newMapStore := func(_descriptor string) (Store, error) {
+		return mapStore{
+			Mu:       &sync.Mutex{},
+			Contents: &mapContents{Links: make(map[string]Link)},
+		}, nil
+	}
+	RegisterStorageMethod("", newMapStore)
+	RegisterStorageMethod("map", newMapStore)
+	RegisterStorageMethod("memory", newMapStore)
+
+	newFSStore := func(fileName string) (Store, error) {
+		rv := mapStore{
+			Mu:       &sync.Mutex{},
+			Contents: &mapContents{Links: make(map[string]Link)},
+			Filename: fileName,
+		}
+
+		f, err := os.Open(fileName)
+		if errors.Is(err, fs.ErrNotExist) {
+			return rv, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		err = dec.Decode(rv.Contents)
+		if rv.Contents.Links == nil {
+			rv.Contents.Links = make(map[string]Link)
+		}
+		return rv, err
+	}
+	RegisterStorageMethod("fs", newFSStore)
+	RegisterStorageMethod("file", newFSStore)
+	RegisterStorageMethod("json", newFSStore)
+}
@@ -0,0 +1,159 @@
+// Package share implements links that give access to a single document to
+// anyone who has them, without having to log in.
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrNotPresent is returned when a share link does not exist
+var ErrNotPresent = errors.New("share link not present")
+
+// ErrNotShared is returned for paths below a share link that are neither its
+// document nor one of the document's attachments
+var ErrNotShared = errors.New("not part of a shared document")
+
+// ErrInactive is returned when a share link has expired, was revoked, or has been viewed too often
+var ErrInactive = errors.New("this share link is no longer valid")
+
+// A Link grants access to a single document
+type Link struct {
+	ID         string
+	DocumentID string
+	Owner      string
+	Created    time.Time
+	Expires    time.Time
+
+	// MaxViews is the number of times the document can be viewed using this
+	// link, or 0 for unlimited views
+	MaxViews int
+	Views    int
+
+	PasswordHash []byte `json:",omitempty"`
+	Revoked      bool   `json:",omitempty"`
+}
+
+// NewLinkID generates a random ID for a share link
+func NewLinkID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Valid checks if this link has neither expired nor been revoked
+func (l Link) Valid(now time.Time) bool {
+	return !l.Revoked && now.Before(l.Expires)
+}
+
+// Active checks if this link can still be used to view its document
+func (l Link) Active(now time.Time) bool {
+	if !l.Valid(now) {
+		return false
+	}
+	return l.MaxViews == 0 || l.Views < l.MaxViews
+}
+
+// CanServe checks if this link can be used to open its document, or one of
+// its attachments. Only views of the document itself count towards MaxViews,
+// so the view that uses up the last one can still load its attachments.
+func (l Link) CanServe(now time.Time, attachment bool) bool {
+	if attachment {
+		return l.Valid(now)
+	}
+	return l.Active(now)
+}
+
+// ParseRequest determines what is requested through a share link, from the
+// path below the link split on slashes. The path is either empty, for the
+// document itself, or att/<name> for one of its attachments, in which case
+// the attachment's name is returned.
+func ParseRequest(rest []string) (attachment string, err error) {
+	if len(rest) == 1 && rest[0] == "" {
+		return "", nil
+	}
+	if len(rest) == 2 && rest[0] == "att" && rest[1] != "" {
+		return rest[1], nil
+	}
+	return "", ErrNotShared
+}
+
+// HasPassword checks if a password is needed to use this link
+func (l Link) HasPassword() bool {
+	return len(l.PasswordHash) > 0
+}
+
+// SetPassword protects this link with a password
+func (l *Link) SetPassword(password string) error {
+	if password == "" {
+		l.PasswordHash = nil
+		return nil
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	l.PasswordHash = h
+	return nil
+}
+
+// CheckPassword checks the password for this link
+func (l Link) CheckPassword(password string) bool {
+	if !l.HasPassword() {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword(l.PasswordHash, []byte(password)) == nil
+}
+
+type Store interface {
+	// NewLink stores a new share link. It assigns the link's ID and creation date.
+	NewLink(context.Context, Link) (Link, error)
+	GetLink(context.Context, string) (Link, error)
+	GetLinksForOwner(context.Context, string) ([]Link, error)
+	RevokeLink(ctx context.Context, owner, id string) error
+
+	// CountView records a view of the document through this link, and
+	// returns ErrInactive if the link can no longer be used
+	CountView(context.Context, string) (Link, error)
+
+	// SigningKey returns the secret key that link tokens are signed with
+	SigningKey(context.Context) ([]byte, error)
+
+	// Prune removes all links that can no longer be used
+	Prune(context.Context) error
+}
+
+type StorageMethod func(string) (Store, error)
+
+var allStorageMethods map[string]StorageMethod
+
+func RegisterStorageMethod(name string, f StorageMethod) {
+	if allStorageMethods == nil {
+		allStorageMethods = make(map[string]StorageMethod)
+	}
+	allStorageMethods[name] = f
+}
+
+func GetStore(descriptor string) (Store, error) {
+	i := strings.IndexRune(descriptor, ':')
+	name := ""
+	if i >= 0 {
+		name = descriptor[:i]
+		descriptor = descriptor[i+1:]
+	}
+	if allStorageMethods == nil {
+		return nil, fmt.Errorf("no storage backends have been initialized")
+	}
+
+	if f, ok := allStorageMethods[name]; ok {
+		return f(descriptor)
+	}
+	return nil, fmt.Errorf("storage backend '%s' not registered", name)
+}
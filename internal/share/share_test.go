@@ -0,0 +1,158 @@
+package share
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareLinks(t *testing.T) {
+	ctx := context.Background()
+	fileName := path.Join(t.TempDir(), "shares.json")
+
+	st, err := GetStore("file:" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := st.SigningKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := Link{
+		DocumentID: "0123456789",
+		Owner:      "alice",
+		Expires:    time.Now().Add(time.Hour),
+		MaxViews:   2,
+	}
+	l.SetPassword("hunter2")
+	l, err = st.NewLink(ctx, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := l.Token(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen the store; the link and the signing key should survive
+	st, err = GetStore("file:" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = st.SigningKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	linkID, docID, err := ParseToken(token, key)
+	if err != nil {
+		t.Fatal(err)
+	} else if linkID != l.ID || docID != l.DocumentID {
+		t.Errorf("Unexpected token contents %s, %s", linkID, docID)
+	}
+	if _, _, err := ParseToken(token+"x", key); err == nil {
+		t.Errorf("Tampered tokens should not be accepted")
+	}
+	if _, _, err := ParseToken(token, []byte("another key")); err == nil {
+		t.Errorf("Tokens signed with another key should not be accepted")
+	}
+
+	l, err = st.GetLink(ctx, linkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.HasPassword() || l.CheckPassword("hunter3") || !l.CheckPassword("hunter2") {
+		t.Errorf("Password check failed")
+	}
+
+	unlock, err := l.UnlockToken(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.CheckUnlockToken(unlock, key) {
+		t.Errorf("Unlock token should be valid")
+	}
+	if l.CheckUnlockToken(token, key) {
+		t.Errorf("A share token should not be usable as an unlock token")
+	}
+
+	// View counts
+	for i := 0; i < 2; i++ {
+		if _, err := st.CountView(ctx, l.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := st.CountView(ctx, l.ID); err != ErrInactive {
+		t.Errorf("Expected ErrInactive after exceeding the view count; got %v", err)
+	}
+
+	// The view that uses up the last one can still load the attachments
+	l3, err := st.NewLink(ctx, Link{DocumentID: "0123456789", Owner: "alice", Expires: time.Now().Add(time.Hour), MaxViews: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l3, err = st.CountView(ctx, l3.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l3.CanServe(time.Now(), false) {
+		t.Errorf("A link with max_views=1 should not serve its document after one view")
+	}
+	if !l3.CanServe(time.Now(), true) {
+		t.Errorf("A link with max_views=1 should still serve its attachments after one view")
+	}
+	for _, p := range []string{"x", "att", "att/", "att/t0000000001.css/x", "history"} {
+		// Anything other than the document and its attachments is not served,
+		// so that it can't be used to read the document without counting a view
+		if _, err := ParseRequest(strings.Split(p, "/")); err != ErrNotShared {
+			t.Errorf("Path '%s' below a share link should not be served; got error %v", p, err)
+		}
+	}
+	if att, err := ParseRequest([]string{"att", "t0000000001.css"}); err != nil || att != "t0000000001.css" {
+		t.Errorf("Attachments should be served through a share link; got '%s', %v", att, err)
+	}
+	if l3.CanServe(time.Now().Add(2*time.Hour), true) {
+		t.Errorf("An expired link should not serve its attachments")
+	}
+	err = st.Prune(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.GetLink(ctx, l3.ID); err != nil {
+		t.Errorf("Links whose views are used up should not be pruned before they expire; got %v", err)
+	}
+	if err := st.RevokeLink(ctx, "alice", l3.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoking
+	l2, err := st.NewLink(ctx, Link{DocumentID: "0123456789", Owner: "alice", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RevokeLink(ctx, "bob", l2.ID); err != ErrNotPresent {
+		t.Errorf("Only the owner should be able to revoke a link; got %v", err)
+	}
+	if err := st.RevokeLink(ctx, "alice", l2.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CountView(ctx, l2.ID); err != ErrInactive {
+		t.Errorf("Expected ErrInactive for a revoked link; got %v", err)
+	}
+
+	err = st.Prune(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := st.GetLinksForOwner(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(links) != 1 || links[0].ID != l.ID {
+		// The first link's views are used up, but it hasn't expired yet
+		t.Errorf("Expected all links except the first to be pruned; got %v", links)
+	}
+}
@@ -0,0 +1,61 @@
+package share
+
+import (
+	"errors"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, have been tampered with, or have expired
+var ErrInvalidToken = errors.New("invalid share token")
+
+const (
+	// audienceView is the audience for tokens that appear in share links
+	audienceView string = "view"
+	// audienceUnlock is the audience for tokens proving that a link's password was entered
+	audienceUnlock string = "unlock"
+)
+
+func (l Link) sign(key []byte, audience string) (string, error) {
+	claims := jwt.RegisteredClaims{
+		ID:        l.ID,
+		Subject:   l.DocumentID,
+		Audience:  jwt.ClaimStrings{audience},
+		IssuedAt:  jwt.NewNumericDate(l.Created),
+		ExpiresAt: jwt.NewNumericDate(l.Expires),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(key)
+}
+
+func parse(token string, key []byte, audience string) (linkID, docID string, err error) {
+	var claims jwt.RegisteredClaims
+	_, err = jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !claims.VerifyAudience(audience, true) || claims.ExpiresAt == nil {
+		return "", "", ErrInvalidToken
+	}
+	return claims.ID, claims.Subject, nil
+}
+
+// Token returns the signed token that identifies this link in a URL
+func (l Link) Token(key []byte) (string, error) {
+	return l.sign(key, audienceView)
+}
+
+// ParseToken verifies a token from a share link, and returns the link and document IDs it contains
+func ParseToken(token string, key []byte) (linkID, docID string, err error) {
+	return parse(token, key, audienceView)
+}
+
+// UnlockToken returns a token proving that the password for this link was entered correctly
+func (l Link) UnlockToken(key []byte) (string, error) {
+	return l.sign(key, audienceUnlock)
+}
+
+// CheckUnlockToken checks if an unlock token is valid for this link
+func (l Link) CheckUnlockToken(token string, key []byte) bool {
+	linkID, docID, err := parse(token, key, audienceUnlock)
+	return err == nil && linkID == l.ID && docID == l.DocumentID
+}
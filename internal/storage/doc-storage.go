@@ -3,10 +3,17 @@ package storage
 import (
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type DocStore interface {
@@ -18,6 +25,9 @@ type DocStore interface {
 
 	// GetDocument starts a transaction for a document ID
 	GetDocument(string) (DocTransaction, error)
+
+	// DeleteDocument permanently removes a document and all its attachments
+	DeleteDocument(context.Context, string) error
 }
 
 type DocTransaction interface {
@@ -36,12 +46,59 @@ type DocTransaction interface {
 	Rollback() error
 }
 
+// ErrReadOnly is returned when trying to modify a read-only transaction
+var ErrReadOnly = errors.New("this transaction is read-only")
+
+// ErrTransactionClosed is returned when using a transaction after it has been committed or rolled back
+var ErrTransactionClosed = errors.New("this transaction has already been closed")
+
+// A ResumableTransaction can be suspended and picked up again later, possibly
+// by a different process. This allows drafts to survive a restart.
+type ResumableTransaction interface {
+	DocTransaction
+
+	// ResumeToken returns an opaque token that describes the current state of
+	// this transaction. The token changes as the transaction is modified.
+	ResumeToken() (string, error)
+}
+
+// A ResumableStore can reopen a transaction from its resume token
+type ResumableStore interface {
+	// ResumeDocument reopens a transaction for a document ID
+	ResumeDocument(docID, token string) (DocTransaction, error)
+}
+
+// ErrInvalidResumeToken is returned when a transaction cannot be resumed from a token
+var ErrInvalidResumeToken = errors.New("invalid resume token")
+
 func NewDocumentID() string {
 	var b []byte = make([]byte, 5)
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
 
+// EncodeResumeToken serialises the state of a transaction into a resume
+// token. Storage backends can use this to implement ResumableTransaction.
+func EncodeResumeToken(state interface{}) (string, error) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeResumeToken reads the state of a transaction from a resume token
+func DecodeResumeToken(token string, state interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ErrInvalidResumeToken
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return ErrInvalidResumeToken
+	}
+	return nil
+}
+
 type StorageMethod func(string) (DocStore, error)
 
 var allStorageMethods map[string]StorageMethod
@@ -76,7 +133,7 @@ func Copy(ctx context.Context, target, source DocStore) error {
 		return err
 	}
 
-	rootFiles := []string{"document.bin", "meta.xml"}
+	rootFiles := []string{"document.bin", "meta.xml", "manifest.xml"}
 
 	for _, id := range ids {
 		err = func(id string) error {
@@ -101,13 +158,16 @@ func Copy(ctx context.Context, target, source DocStore) error {
 
 			// TODO: range over all root files, not just the ones I remembered to mention in the list above
 			for _, rf := range rootFiles {
-				g, err := trnsTgt.WriteRootFile(ctx, rf)
-				if err != nil {
+				f, err := trnsSrc.ReadRootFile(ctx, rf)
+				if rf == "manifest.xml" && errors.Is(err, fs.ErrNotExist) {
+					// Older documents don't have a manifest
+					continue
+				} else if err != nil {
 					return err
 				}
-				f, err := trnsSrc.ReadRootFile(ctx, rf)
+				g, err := trnsTgt.WriteRootFile(ctx, rf)
 				if err != nil {
-					g.Close()
+					f.Close()
 					return err
 				}
 				_, err = io.Copy(g, f)
@@ -155,7 +215,48 @@ type ExtensionKnower interface {
 	AttachmentNameFromID(context.Context, string) (string, error)
 }
 
-var knownExtensions []string = []string{"css", "svg", "png", "jpeg", "ico", "woff", "woff2", "eot", "ttf"}
+var knownExtensions []string = []string{"css", "svg", "png", "jpeg", "gif", "webp", "ico", "woff", "woff2", "eot", "ttf", "otf"}
+
+// IsAttachmentExtension checks if attachments can have a file extension
+func IsAttachmentExtension(ext string) bool {
+	for _, e := range knownExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentTypes maps the content types allowed for attachments to their
+// extension, including some nonstandard names that servers use for them
+var attachmentTypes map[string]string = map[string]string{
+	"text/css":                      "css",
+	"image/svg+xml":                 "svg",
+	"image/png":                     "png",
+	"image/jpeg":                    "jpeg",
+	"image/jpg":                     "jpeg",
+	"image/gif":                     "gif",
+	"image/webp":                    "webp",
+	"image/x-icon":                  "ico",
+	"image/vnd.microsoft.icon":      "ico",
+	"font/woff":                     "woff",
+	"application/font-woff":         "woff",
+	"application/x-font-woff":       "woff",
+	"font/woff2":                    "woff2",
+	"application/vnd.ms-fontobject": "eot",
+	"font/embedded-opentype":        "eot",
+	"font/ttf":                      "ttf",
+	"application/x-font-ttf":        "ttf",
+	"font/otf":                      "otf",
+}
+
+// AttachmentExtension returns the file extension for an attachment with a
+// content type, or false if attachments of that type are not allowed
+func AttachmentExtension(contentType string) (string, bool) {
+	contentType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	ext, ok := attachmentTypes[contentType]
+	return ext, ok
+}
 
 func AttachmentNameFromID(ctx context.Context, trns DocTransaction, att_id string) (string, error) {
 	if ek, ok := trns.(ExtensionKnower); ok {
@@ -199,9 +300,11 @@ func GetRootFile(ctx context.Context, st DocStore, doc_id, name string) (io.Read
 	return f, nil
 }
 
-func GetAttachment(ctx context.Context, st DocStore, doc_id, att_id string) (io.ReadCloser, error) {
+// GetAttachment reads an attachment by its file name. The extension has to
+// match the stored attachment, so that it can't be served as another type.
+func GetAttachment(ctx context.Context, st DocStore, doc_id, name string) (io.ReadCloser, error) {
 	if dfa, ok := st.(DirectFileAccesser); ok {
-		return dfa.GetAttachment(ctx, doc_id, att_id)
+		return dfa.GetAttachment(ctx, doc_id, name)
 	}
 
 	trns, err := st.GetDocument(doc_id)
@@ -210,15 +313,132 @@ func GetAttachment(ctx context.Context, st DocStore, doc_id, att_id string) (io.
 	}
 	defer trns.Rollback()
 
-	var f io.ReadCloser
-	for _, e := range knownExtensions {
-		attName := "t" + att_id + "." + e
-		f, err = trns.ReadAttachment(ctx, attName)
-		if err == nil {
-			return f, nil
+	return trns.ReadAttachment(ctx, name)
+}
+
+// A DocumentHistory can list earlier versions of a document, and open them for reading
+type DocumentHistory interface {
+	// DocumentRevisions lists all revisions of a document, most recent first
+	DocumentRevisions(context.Context, string) ([]Revision, error)
+
+	// GetDocumentRevision starts a read-only transaction for a document at a specific revision
+	GetDocumentRevision(string, string) (DocTransaction, error)
+}
+
+// A DocumentVersioner can tell if a document has changed without reading it
+type DocumentVersioner interface {
+	// DocumentVersion returns an opaque string that changes every time the
+	// document is committed
+	DocumentVersion(context.Context, string) (string, error)
+}
+
+// DocumentVersion returns a string that changes every time a document is
+// committed, or an empty string if the store can't tell
+func DocumentVersion(ctx context.Context, st DocStore, docID string) string {
+	dv, ok := st.(DocumentVersioner)
+	if !ok {
+		return ""
+	}
+	rv, err := dv.DocumentVersion(ctx, docID)
+	if err != nil {
+		return ""
+	}
+	return rv
+}
+
+type Revision struct {
+	ID      string
+	Date    time.Time
+	Message string
+}
+
+// A Capture is a single snapshot of a web page
+type Capture struct {
+	DocumentID string
+	Revision   string
+	Meta       DocumentMeta
+}
+
+// URLCaptures lists all captures of a web page that are visible to this user,
+// in chronological order. If the storage backend supports it, this includes
+// earlier versions of documents that have since been overwritten.
+func URLCaptures(ctx context.Context, st DocStore, cache DocumentCache, user Principal, page_url string) ([]Capture, error) {
+	ids, metas, err := cache.GetDocumentsByURL(ctx, user, page_url)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := []Capture{}
+	for i, docid := range ids {
+		rv = append(rv, Capture{
+			DocumentID: docid,
+			Meta:       metas[i],
+		})
+
+		dh, ok := st.(DocumentHistory)
+		if !ok {
+			continue
 		}
+
+		revs, err := dh.DocumentRevisions(ctx, docid)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[time.Time]bool{
+			metas[i].CaptureDate: true,
+		}
+		for _, rev := range revs {
+			trns, err := dh.GetDocumentRevision(docid, rev.ID)
+			if err != nil {
+				return nil, err
+			}
+			meta, err := ReadMeta(ctx, trns)
+			trns.Rollback()
+			if err != nil || meta.Status != StatusStatic || meta.URL != page_url || seen[meta.CaptureDate] {
+				continue
+			}
+			seen[meta.CaptureDate] = true
+
+			// Use the current permissions rather than the historical ones
+			meta.Permissions = metas[i].Permissions
+
+			rv = append(rv, Capture{
+				DocumentID: docid,
+				Revision:   rev.ID,
+				Meta:       meta,
+			})
+		}
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Meta.CaptureDate.Before(rv[j].Meta.CaptureDate)
+	})
+	return rv, nil
+}
+
+// DocumentURLs lists the original URLs of all documents in a store that are
+// not in the trash, without duplicates, in sorted order.
+func DocumentURLs(ctx context.Context, st DocStore, cache DocumentCache) ([]string, error) {
+	ids, err := st.DocumentIDs(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+
+	seen := make(map[string]bool)
+	rv := []string{}
+	for _, id := range ids {
+		meta, err := cache.GetDocumentMeta(ctx, id)
+		if err != nil {
+			continue
+		}
+		if meta.URL == "" || meta.Status != StatusStatic || seen[meta.URL] {
+			continue
+		}
+		seen[meta.URL] = true
+		rv = append(rv, meta.URL)
+	}
+	sort.Strings(rv)
+	return rv, nil
 }
 
 type Limit struct {
@@ -226,10 +446,137 @@ type Limit struct {
 	Limit  int
 }
 
+// A Filter restricts a list of documents to those matching some criteria.
+// The zero value matches all documents.
+type Filter struct {
+	// Tags contains tags that documents should have. Documents should have all of them to match.
+	Tags []string
+}
+
+// Matches checks if a document matches this filter
+func (f Filter) Matches(meta DocumentMeta) bool {
+	return meta.HasTags(f.Tags...)
+}
+
+// A TagCount is the number of documents that have a tag
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// CountTags counts the occurrences of all tags in a list of documents, sorted by tag
+func CountTags(metas []DocumentMeta) []TagCount {
+	counts := make(map[string]int)
+	for _, meta := range metas {
+		for _, tag := range meta.Tags {
+			counts[tag]++
+		}
+	}
+
+	rv := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		rv = append(rv, TagCount{tag, n})
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Tag < rv[j].Tag
+	})
+	return rv
+}
+
+// LatestCaptures reduces a list of documents to the most recent capture of
+// each URL that matches the filter, in the order in which the URLs first
+// appear. Documents without a URL are kept as they are. The limit applies to
+// the reduced list. The counts contain the number of documents in the list
+// that have the same URL, regardless of the filter.
+func LatestCaptures(ids []string, metas []DocumentMeta, filter Filter, limit Limit) ([]string, []DocumentMeta, []int) {
+	perURL := make(map[string]int)
+	for _, meta := range metas {
+		perURL[meta.URL]++
+	}
+
+	rvids := []string{}
+	rv := []DocumentMeta{}
+	byURL := make(map[string]int)
+	for i, docid := range ids {
+		meta := metas[i]
+		if !filter.Matches(meta) {
+			continue
+		}
+		if j, ok := byURL[meta.URL]; ok && meta.URL != "" {
+			if meta.CaptureDate.After(rv[j].CaptureDate) {
+				rvids[j], rv[j] = docid, meta
+			}
+			continue
+		}
+		byURL[meta.URL] = len(rv)
+		rvids = append(rvids, docid)
+		rv = append(rv, meta)
+	}
+
+	if limit.Offset >= len(rv) {
+		return []string{}, []DocumentMeta{}, []int{}
+	}
+	rvids, rv = rvids[limit.Offset:], rv[limit.Offset:]
+	if limit.Limit > 0 && len(rv) > limit.Limit {
+		rvids, rv = rvids[:limit.Limit], rv[:limit.Limit]
+	}
+
+	counts := make([]int, len(rv))
+	for i, meta := range rv {
+		counts[i] = 1
+		if meta.URL != "" {
+			counts[i] = perURL[meta.URL]
+		}
+	}
+	return rvids, rv, counts
+}
+
+// A ChangeNotifier can inform other components whenever a document in the store has changed
+type ChangeNotifier interface {
+	OnChange(ChangeFunc)
+}
+
+type ChangeFunc func(ctx context.Context, docID string)
+
+// ChangeHooks is a helper for storage backends that implement ChangeNotifier
+type ChangeHooks struct {
+	mu    sync.Mutex
+	hooks []ChangeFunc
+}
+
+// OnChange registers a function to be called after a document changes
+func (h *ChangeHooks) OnChange(f ChangeFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, f)
+}
+
+// Changed calls all registered hooks for this document ID
+func (h *ChangeHooks) Changed(ctx context.Context, docID string) {
+	h.mu.Lock()
+	hooks := make([]ChangeFunc, len(h.hooks))
+	copy(hooks, h.hooks)
+	h.mu.Unlock()
+
+	for _, f := range hooks {
+		f(ctx, docID)
+	}
+}
+
 type DocumentCache interface {
-	GetDocuments(context.Context, string, Limit) ([]string, []DocumentMeta, error)
+	GetDocuments(context.Context, Principal, Filter, Limit) ([]string, []DocumentMeta, error)
 	GetDocumentByURL(context.Context, string, string) (DocTransaction, bool, error)
+	GetDocumentsByURL(context.Context, Principal, string) ([]string, []DocumentMeta, error)
 	GetDocumentMeta(context.Context, string) (DocumentMeta, error)
+	GetTrash(context.Context, string) ([]string, []DocumentMeta, error)
+	GetTags(context.Context, Principal) ([]TagCount, error)
+	GetLatestCaptures(context.Context, Principal, Filter, Limit) ([]string, []DocumentMeta, []int, error)
+}
+
+// A Reconciler is a DocumentCache that can catch up with documents that were
+// added or removed by another process
+type Reconciler interface {
+	Reconcile(context.Context) error
 }
 
 type CacheMethod func(string, DocStore) (DocumentCache, error)
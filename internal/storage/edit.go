@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// A MetaEdit contains new values for the editable properties of a document
+type MetaEdit struct {
+	Title  string
+	Author string
+	Date   time.Time
+
+	// Permissions replaces the access control lists of the document. Its
+	// owner is ignored: the owner of a document cannot be changed.
+	Permissions Permissions
+}
+
+// EditMeta changes the title, author, date and permissions of a document in a
+// single transaction. The commit message lists the properties that changed,
+// which are also returned. If nothing changed, no commit is made.
+func EditMeta(ctx context.Context, st DocStore, docID string, edit MetaEdit) ([]string, error) {
+	trns, err := st.GetDocument(docID)
+	if err != nil {
+		return nil, err
+	}
+	defer trns.Rollback()
+
+	meta, err := ReadMeta(ctx, trns)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	if meta.Title != edit.Title {
+		meta.Title = edit.Title
+		changed = append(changed, "title")
+	}
+	if meta.Author != edit.Author {
+		meta.Author = edit.Author
+		changed = append(changed, "author")
+	}
+	if !meta.Date.Equal(edit.Date) {
+		meta.Date = edit.Date
+		changed = append(changed, "date")
+	}
+
+	perms := edit.Permissions
+	perms.Owner = meta.Permissions.Owner
+	if meta.Permissions.Public != perms.Public {
+		changed = append(changed, "visibility")
+	}
+	if !sameList(meta.Permissions.ReadUsers, perms.ReadUsers) || !sameList(meta.Permissions.ReadGroups, perms.ReadGroups) ||
+		!sameList(meta.Permissions.WriteUsers, perms.WriteUsers) || !sameList(meta.Permissions.WriteGroups, perms.WriteGroups) {
+		changed = append(changed, "access lists")
+	}
+	meta.Permissions = perms
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	err = WriteMeta(ctx, trns, meta)
+	if err != nil {
+		return nil, err
+	}
+	return changed, trns.Commit(ctx, "Change "+joinList(changed))
+}
+
+// sameList compares two lists, considering nil and empty lists equal
+func sameList(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// joinList formats a list like "a, b and c"
+func joinList(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
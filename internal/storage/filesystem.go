@@ -8,25 +8,99 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 func init() {
 	f := func(rootPath string) (DocStore, error) {
 		rv := jankyFS{
 			RootDirectory: rootPath,
+			changes:       &ChangeHooks{},
+			mu:            &sync.RWMutex{},
 		}
 		if rootPath == "" {
 			rv.RootDirectory = "doc"
 		}
 
+		err := rv.cleanStaging(time.Now().Add(-staleAfter))
+		if err != nil {
+			return nil, err
+		}
+
 		return rv, nil
 	}
 	RegisterStorageMethod("", f)
 	RegisterStorageMethod("fs", f)
 }
 
+// stagingDirectory contains the scratch areas of all open transactions. It
+// has to be on the same filesystem as the documents, so that staged files can
+// be moved into place.
+const stagingDirectory string = ".staging"
+
+// staleAfter is the age after which a directory used to publish or delete a
+// document is considered left behind by a crash
+const staleAfter time.Duration = time.Hour
+
 type jankyFS struct {
 	RootDirectory string
+	changes       *ChangeHooks
+
+	// mu guards swapping a document directory for its new version. Readers
+	// hold a read lock while opening a file, so that they never observe a
+	// missing or partially published document.
+	mu *sync.RWMutex
+}
+
+// OnChange registers a function that gets called whenever a document is committed
+func (jfs jankyFS) OnChange(f ChangeFunc) {
+	jfs.changes.OnChange(f)
+}
+
+// cleanStaging removes the directories that were left behind in the staging
+// area when publishing or deleting a document was interrupted. If a document
+// was interrupted between moving its old version away and moving the new one
+// in, the old version is put back. Scratch directories are left alone, as they
+// may belong to drafts that can still be resumed.
+func (jfs jankyFS) cleanStaging(before time.Time) error {
+	stagingRoot := path.Join(jfs.RootDirectory, stagingDirectory)
+	entries, err := os.ReadDir(stagingRoot)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, fi := range entries {
+		name := fi.Name()
+		if len(name) < 11 || name[0] != 'g' || !fi.IsDir() {
+			continue
+		}
+		docDir, rest := name[:11], name[11:]
+		if !strings.HasPrefix(rest, "-next-") && !strings.HasPrefix(rest, "-deleted-") {
+			continue
+		}
+		if info, err := fi.Info(); err != nil || info.ModTime().After(before) {
+			continue
+		}
+
+		if strings.HasPrefix(rest, "-next-") && strings.HasSuffix(rest, "-old") {
+			live := path.Join(jfs.RootDirectory, docDir)
+			if _, err := os.Stat(live); errors.Is(err, fs.ErrNotExist) {
+				if err := os.Rename(path.Join(stagingRoot, name), live); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := os.RemoveAll(path.Join(stagingRoot, name)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // DocumentIDs lists all ID's for documents in this store
@@ -38,6 +112,7 @@ func (jfs jankyFS) DocumentIDs(context.Context) ([]string, error) {
 		}
 		return nil, err
 	}
+	defer d.Close()
 	contents, err := d.ReadDir(-1)
 	if err != nil {
 		return nil, err
@@ -53,6 +128,18 @@ func (jfs jankyFS) DocumentIDs(context.Context) ([]string, error) {
 	return rv, nil
 }
 
+// DocumentVersion returns the modification time of a document's directory.
+// Every commit publishes a new directory, so it changes with every commit.
+func (jfs jankyFS) DocumentVersion(ctx context.Context, docID string) (string, error) {
+	jfs.mu.RLock()
+	defer jfs.mu.RUnlock()
+	fi, err := os.Stat(path.Join(jfs.RootDirectory, "g"+docID))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", fi.ModTime().UnixNano()), nil
+}
+
 // NewDocumentID generates a new document ID that is not yet present in this store
 func (jfs jankyFS) NewDocumentID(ctx context.Context) (string, error) {
 	var rv string
@@ -76,47 +163,200 @@ func (jfs jankyFS) GetDocument(docID string) (DocTransaction, error) {
 		return nil, fmt.Errorf("invalid document ID")
 	}
 
-	return jankyTransaction{
+	return &jankyTransaction{
 		RootDirectory: jfs.RootDirectory,
 		DocID:         docID,
+		changes:       jfs.changes,
+		mu:            jfs.mu,
+		deleted:       make(map[string]bool),
 	}, nil
 }
 
+// ResumeDocument reopens a transaction from its resume token. Staged files
+// are kept in the staging area, so they are still there after a restart.
+func (jfs jankyFS) ResumeDocument(docID, token string) (DocTransaction, error) {
+	trns, err := jfs.GetDocument(docID)
+	if err != nil {
+		return nil, err
+	}
+	t := trns.(*jankyTransaction)
+
+	var state jankyResumeState
+	if err := DecodeResumeToken(token, &state); err != nil {
+		return nil, err
+	}
+	if state.Scratch != "" {
+		if path.Base(state.Scratch) != state.Scratch || !strings.HasPrefix(state.Scratch, "g"+docID+"-") {
+			return nil, ErrInvalidResumeToken
+		}
+		t.scratch = path.Join(jfs.RootDirectory, stagingDirectory, state.Scratch)
+		if fi, err := os.Stat(t.scratch); err != nil || !fi.IsDir() {
+			return nil, ErrInvalidResumeToken
+		}
+	}
+	for _, name := range state.Deleted {
+		t.deleted[name] = true
+	}
+
+	return t, nil
+}
+
+// DeleteDocument permanently removes a document directory
+func (jfs jankyFS) DeleteDocument(ctx context.Context, docID string) error {
+	if len(docID) != 10 {
+		return fmt.Errorf("invalid document ID")
+	}
+	live := path.Join(jfs.RootDirectory, "g"+docID)
+
+	stagingRoot := path.Join(jfs.RootDirectory, stagingDirectory)
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		return err
+	}
+	old, err := os.MkdirTemp(stagingRoot, "g"+docID+"-deleted-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(old)
+
+	// Move the document out of the way first, so that readers never see a partially deleted document
+	jfs.mu.Lock()
+	err = os.Rename(live, path.Join(old, "g"+docID))
+	jfs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	jfs.changes.Changed(ctx, docID)
+	return nil
+}
+
+// A jankyTransaction stages all writes in a scratch directory. Reads see the
+// staged files on top of the published document. On commit, a new version of
+// the document directory is assembled and swapped in for the old one.
 type jankyTransaction struct {
 	RootDirectory string
 	DocID         string
+	changes       *ChangeHooks
+	mu            *sync.RWMutex
+
+	// scratch is the staging area for this transaction. It is created on the first write.
+	scratch string
+	// deleted contains the names of all attachments deleted in this transaction
+	deleted map[string]bool
+	closed  bool
 }
 
-func (t jankyTransaction) DocumentID() string {
+func (t *jankyTransaction) DocumentID() string {
 	return t.DocID
 }
 
-func (t jankyTransaction) ReadRootFile(ctx context.Context, name string) (io.ReadCloser, error) {
-	return os.Open(path.Join(t.RootDirectory, "g"+t.DocID, name))
+func (t *jankyTransaction) docDir() string {
+	return path.Join(t.RootDirectory, "g"+t.DocID)
 }
-func (t jankyTransaction) WriteRootFile(ctx context.Context, name string) (io.WriteCloser, error) {
-	os.MkdirAll(path.Join(t.RootDirectory, "g"+t.DocID), 0755)
-	return os.Create(path.Join(t.RootDirectory, "g"+t.DocID, name))
+
+// openLive opens a file in the published version of this document
+func (t *jankyTransaction) openLive(name string) (*os.File, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return os.Open(path.Join(t.docDir(), name))
 }
 
-func (t jankyTransaction) ListAttachments(ctx context.Context) ([]string, error) {
-	d, err := os.Open(path.Join(t.RootDirectory, "g"+t.DocID, "att"))
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			return nil, nil
-		} else {
+// open opens a file, preferring the staged version if there is one
+func (t *jankyTransaction) open(name string) (io.ReadCloser, error) {
+	if t.closed {
+		return nil, ErrTransactionClosed
+	}
+	if t.deleted[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if t.scratch != "" {
+		f, err := os.Open(path.Join(t.scratch, name))
+		if err == nil {
+			return f, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
 			return nil, err
 		}
 	}
-	contents, err := d.ReadDir(-1)
+	return t.openLive(name)
+}
+
+// create creates a file in the staging area
+func (t *jankyTransaction) create(name string) (*os.File, error) {
+	if t.closed {
+		return nil, ErrTransactionClosed
+	}
+	if t.scratch == "" {
+		stagingRoot := path.Join(t.RootDirectory, stagingDirectory)
+		err := os.MkdirAll(stagingRoot, 0755)
+		if err != nil {
+			return nil, err
+		}
+		t.scratch, err = os.MkdirTemp(stagingRoot, "g"+t.DocID+"-*")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := os.MkdirAll(path.Join(t.scratch, path.Dir(name)), 0755)
 	if err != nil {
 		return nil, err
 	}
+	delete(t.deleted, name)
+	return os.Create(path.Join(t.scratch, name))
+}
 
-	rv := make([]string, 0, len(contents))
-	for _, fi := range contents {
-		n := fi.Name()
-		if fi.IsDir() || len(n) < 12 || n[0] != 't' || n[11] != '.' {
+// exists checks if a file exists either in the staging area or in the published document
+func (t *jankyTransaction) exists(name string) (bool, error) {
+	f, err := t.open(name)
+	if err == nil {
+		f.Close()
+		return true, nil
+	} else if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (t *jankyTransaction) ReadRootFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	return t.open(name)
+}
+func (t *jankyTransaction) WriteRootFile(ctx context.Context, name string) (io.WriteCloser, error) {
+	return t.create(name)
+}
+
+func (t *jankyTransaction) ListAttachments(ctx context.Context) ([]string, error) {
+	if t.closed {
+		return nil, ErrTransactionClosed
+	}
+
+	names := make(map[string]bool)
+	t.mu.RLock()
+	live, err := os.ReadDir(path.Join(t.docDir(), "att"))
+	t.mu.RUnlock()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, fi := range live {
+		if !fi.IsDir() && !t.deleted["att/"+fi.Name()] {
+			names[fi.Name()] = true
+		}
+	}
+
+	if t.scratch != "" {
+		staged, err := os.ReadDir(path.Join(t.scratch, "att"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, fi := range staged {
+			if !fi.IsDir() {
+				names[fi.Name()] = true
+			}
+		}
+	}
+
+	rv := make([]string, 0, len(names))
+	for n := range names {
+		if len(n) < 12 || n[0] != 't' || n[11] != '.' {
 			continue
 		}
 
@@ -127,44 +367,218 @@ func (t jankyTransaction) ListAttachments(ctx context.Context) ([]string, error)
 		}
 		rv = append(rv, n)
 	}
+	sort.Strings(rv)
 	return rv, nil
 }
-func (t jankyTransaction) ReadAttachment(ctx context.Context, name string) (io.ReadCloser, error) {
-	return os.Open(path.Join(t.RootDirectory, "g"+t.DocID, "att", name))
+func (t *jankyTransaction) ReadAttachment(ctx context.Context, name string) (io.ReadCloser, error) {
+	return t.open(path.Join("att", name))
 }
-func (t jankyTransaction) NewAttachmentID(ctx context.Context, ext string) (string, error) {
-	os.MkdirAll(path.Join(t.RootDirectory, "g"+t.DocID, "att"), 0755)
+func (t *jankyTransaction) NewAttachmentID(ctx context.Context, ext string) (string, error) {
 	var rv string
 	for ctx.Err() == nil {
 		rv = NewDocumentID()
-		fp := path.Join(t.RootDirectory, "g"+t.DocID, "att", "t"+rv+"."+ext)
-		_, err := os.Stat(fp)
+		name := path.Join("att", "t"+rv+"."+ext)
+		exists, err := t.exists(name)
 		if err != nil {
-			if errors.Is(err, fs.ErrNotExist) {
-				g, err := os.Create(fp)
-				if err == nil {
-					g.Close()
-					return rv, nil
-				}
-			} else {
+			return "", err
+		}
+		if !exists {
+			g, err := t.create(name)
+			if err != nil {
 				return "", err
 			}
+			return rv, g.Close()
 		}
 	}
 	return "", ctx.Err()
 }
-func (t jankyTransaction) WriteAttachment(ctx context.Context, name string) (io.WriteCloser, error) {
-	os.MkdirAll(path.Join(t.RootDirectory, "g"+t.DocID, "att"), 0755)
-	return os.Create(path.Join(t.RootDirectory, "g"+t.DocID, "att", name))
+func (t *jankyTransaction) WriteAttachment(ctx context.Context, name string) (io.WriteCloser, error) {
+	return t.create(path.Join("att", name))
 }
-func (t jankyTransaction) DeleteAttachment(ctx context.Context, name string) error {
-	return os.Remove(path.Join(t.RootDirectory, "g"+t.DocID, "att", name))
+func (t *jankyTransaction) DeleteAttachment(ctx context.Context, name string) error {
+	name = path.Join("att", name)
+	exists, err := t.exists(name)
+	if err != nil {
+		return err
+	} else if !exists {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if t.scratch != "" {
+		err := os.Remove(path.Join(t.scratch, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	t.deleted[name] = true
+	return nil
+}
+
+type jankyResumeState struct {
+	Scratch string   `json:",omitempty"`
+	Deleted []string `json:",omitempty"`
+}
+
+// ResumeToken returns a token from which this transaction can be resumed
+func (t *jankyTransaction) ResumeToken() (string, error) {
+	if t.closed {
+		return "", ErrTransactionClosed
+	}
+
+	state := jankyResumeState{}
+	if t.scratch != "" {
+		state.Scratch = path.Base(t.scratch)
+	}
+	for name := range t.deleted {
+		state.Deleted = append(state.Deleted, name)
+	}
+	sort.Strings(state.Deleted)
+
+	return EncodeResumeToken(state)
 }
 
-func (t jankyTransaction) Commit(ctx context.Context, name string) error {
+// Commit publishes all staged changes at once
+func (t *jankyTransaction) Commit(ctx context.Context, name string) error {
+	if t.closed {
+		return ErrTransactionClosed
+	}
+	if t.scratch == "" && len(t.deleted) == 0 {
+		// Nothing to publish
+		t.closed = true
+		return nil
+	}
+
+	err := t.publish()
+	if err != nil {
+		return err
+	}
+	t.closed = true
+
+	t.changes.Changed(ctx, t.DocID)
 	return nil
 }
 
-func (t jankyTransaction) Rollback() error {
+func (t *jankyTransaction) publish() error {
+	stagingRoot := path.Join(t.RootDirectory, stagingDirectory)
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		return err
+	}
+	next, err := os.MkdirTemp(stagingRoot, "g"+t.DocID+"-next-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(next)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Assemble the new version of the document from the published files and
+	// the staged ones. Published files are never modified in place, so they
+	// can be linked rather than copied.
+	live := t.docDir()
+	for _, dir := range []string{"", "att"} {
+		if err := os.MkdirAll(path.Join(next, dir), 0755); err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(path.Join(live, dir))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		for _, fi := range entries {
+			name := path.Join(dir, fi.Name())
+			if fi.IsDir() || t.deleted[name] {
+				continue
+			}
+			if err := linkOrCopy(path.Join(live, name), path.Join(next, name)); err != nil {
+				return err
+			}
+		}
+
+		if t.scratch == "" {
+			continue
+		}
+		entries, err = os.ReadDir(path.Join(t.scratch, dir))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		for _, fi := range entries {
+			name := path.Join(dir, fi.Name())
+			if fi.IsDir() {
+				continue
+			}
+			// Staged files stay in the scratch directory until the new version
+			// is in place, so that the transaction is intact if this fails
+			os.Remove(path.Join(next, name))
+			if err := linkOrCopy(path.Join(t.scratch, name), path.Join(next, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Swap the directories. Other processes using the same directory can see
+	// the document missing between the two renames; within this process, the
+	// lock keeps readers out.
+	old := next + "-old"
+	if err := os.Rename(live, old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(next, live); err != nil {
+		os.Rename(old, live)
+		return err
+	}
+	os.RemoveAll(old)
+	if t.scratch != "" {
+		os.RemoveAll(t.scratch)
+		t.scratch = ""
+	}
+
 	return nil
 }
+
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	g, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(g, f)
+	if err != nil {
+		g.Close()
+		return err
+	}
+	return g.Close()
+}
+
+// Rollback discards all staged changes
+func (t *jankyTransaction) Rollback() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	if t.scratch == "" {
+		// Nothing was written, so this transaction did not create the
+		// document directory either. Leave it alone, as it may have been
+		// reserved by NewDocumentID for another transaction.
+		return nil
+	}
+
+	err := os.RemoveAll(t.scratch)
+	t.scratch = ""
+
+	// If this transaction was meant to create a new document, don't leave an empty directory behind
+	t.mu.Lock()
+	os.Remove(t.docDir())
+	t.mu.Unlock()
+
+	return err
+}
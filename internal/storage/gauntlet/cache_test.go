@@ -0,0 +1,275 @@
+package gauntlet
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestIndexCache(t *testing.T) {
+	ctx := context.Background()
+	owner := "dfd17d00-ac7a-4fa8-8773-dc436b1d7a89"
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			dir := t.TempDir()
+			err := extractTar(dir, "testdata/"+scheme+".tar")
+			if err != nil {
+				t.Fatalf("failed to open %s.tar: %v", scheme, err)
+			}
+
+			r, err := storage.GetDocStore(scheme + ":" + dir)
+			if err != nil {
+				t.Fatalf("Cannot initialize doc store %s:%s: %v", scheme, dir, err)
+			}
+
+			indexFile := path.Join(t.TempDir(), "index.json")
+			cache, err := storage.GetDocumentCache("index:"+indexFile, r)
+			if err != nil {
+				t.Fatalf("Cannot initialize document index: %v", err)
+			}
+
+			ids, _, err := cache.GetDocuments(ctx, storage.Principal{UserID: owner}, storage.Filter{}, storage.Limit{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 1 || ids[0] != "43dbc153e5" {
+				t.Errorf("Expected only document 43dbc153e5; got %v", ids)
+			}
+			ids, _, err = cache.GetDocuments(ctx, storage.Principal{UserID: "someone-else"}, storage.Filter{}, storage.Limit{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 0 {
+				t.Errorf("Private document is visible to other users: %v", ids)
+			}
+
+			trns, ok, err := cache.GetDocumentByURL(ctx, owner, "http://example.org/")
+			if err != nil {
+				t.Fatal(err)
+			} else if !ok {
+				t.Fatalf("Document not found by URL")
+			} else if trns.DocumentID() != "43dbc153e5" {
+				t.Errorf("Wrong document found by URL: %s", trns.DocumentID())
+			}
+			trns.Rollback()
+
+			// Add a new public document, and check that the index picks it up
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err = r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta := storage.DocumentMeta{
+				Title:       "Another document",
+				URL:         "http://example.com/",
+				Status:      storage.StatusStatic,
+				CaptureDate: time.Now(),
+			}
+			meta.Permissions.Owner = "someone-else"
+			meta.Permissions.Public = true
+			err = storage.WriteMeta(ctx, trns, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = trns.Commit(ctx, "Add another document")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids, _, err = cache.GetDocuments(ctx, storage.Principal{UserID: owner}, storage.Filter{}, storage.Limit{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 2 {
+				t.Errorf("Expected two documents; got %v", ids)
+			}
+
+			// Reopening the index should only read documents it doesn't know
+			// about. Changes are written to the index file after a while, so
+			// the new document may not be in it yet.
+			counter := &countingStore{DocStore: r}
+			cache, err = storage.GetDocumentCache("index:"+indexFile, counter)
+			if err != nil {
+				t.Fatalf("Cannot reopen document index: %v", err)
+			}
+			if counter.opened > 1 {
+				t.Errorf("Reopening the index read %d documents", counter.opened)
+			}
+			meta, err = cache.GetDocumentMeta(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			} else if meta.Title != "Another document" {
+				t.Errorf("Unexpected title '%s'", meta.Title)
+			}
+			ids, _, err = cache.GetDocuments(ctx, storage.Principal{}, storage.Filter{}, storage.Limit{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 1 || ids[0] != id {
+				t.Errorf("Expected only public document %s; got %v", id, ids)
+			}
+
+			// Documents added, modified or deleted by another process, e.g. a
+			// command line subcommand, are picked up when the index is reopened
+			other, err := storage.GetDocStore(scheme + ":" + dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err = other.GetDocument("43dbc153e5")
+			if err != nil {
+				t.Fatal(err)
+			}
+			modified, err := storage.ReadMeta(ctx, trns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			modified.Title = "Modified elsewhere"
+			err = storage.WriteMeta(ctx, trns, modified)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = trns.Commit(ctx, "Modify a document from another process")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = other.DeleteDocument(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			added, err := other.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err = other.GetDocument(added)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta.Title = "Added elsewhere"
+			err = storage.WriteMeta(ctx, trns, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = trns.Commit(ctx, "Add a document from another process")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cache, err = storage.GetDocumentCache("index:"+indexFile, r)
+			if err != nil {
+				t.Fatalf("Cannot reopen document index: %v", err)
+			}
+			ids, _, err = cache.GetDocuments(ctx, storage.Principal{}, storage.Filter{}, storage.Limit{Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 1 || ids[0] != added {
+				t.Errorf("Expected only document %s added by another process; got %v", added, ids)
+			}
+			meta, err = cache.GetDocumentMeta(ctx, "43dbc153e5")
+			if err != nil {
+				t.Fatal(err)
+			} else if meta.Title != modified.Title {
+				t.Errorf("Expected the title of a document modified by another process; got '%s'", meta.Title)
+			}
+		})
+	}
+}
+
+// countingStore counts the number of documents that are opened
+type countingStore struct {
+	storage.DocStore
+	opened int
+}
+
+func (c *countingStore) GetDocument(id string) (storage.DocTransaction, error) {
+	c.opened++
+	return c.DocStore.GetDocument(id)
+}
+
+func (c *countingStore) DocumentVersion(ctx context.Context, id string) (string, error) {
+	return c.DocStore.(storage.DocumentVersioner).DocumentVersion(ctx, id)
+}
+
+func TestLatestCaptures(t *testing.T) {
+	ctx := context.Background()
+	owner := storage.Principal{UserID: "alice"}
+
+	for _, cacheType := range []string{"nocache", "index"} {
+		t.Run(cacheType, func(t *testing.T) {
+			r, err := storage.GetDocStore("fs:" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc := ""
+			if cacheType == "index" {
+				desc = "index:" + path.Join(t.TempDir(), "index.json")
+			}
+			cache, err := storage.GetDocumentCache(desc, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			latest := map[string]string{}
+			captures := map[string]int{}
+			for i, u := range []string{"http://example.org/a", "http://example.org/b", "http://example.org/a", "http://example.org/c", "http://example.org/a"} {
+				id, err := r.NewDocumentID(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				trns, err := r.GetDocument(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				meta := storage.DocumentMeta{
+					Title:       u,
+					URL:         u,
+					Status:      storage.StatusStatic,
+					CaptureDate: start.Add(time.Duration(i) * time.Hour),
+				}
+				meta.Permissions.Owner = owner.UserID
+				err = storage.WriteMeta(ctx, trns, meta)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = trns.Commit(ctx, "capture "+u)
+				if err != nil {
+					t.Fatal(err)
+				}
+				latest[u] = id
+				captures[u]++
+			}
+
+			ids, metas, counts, err := cache.GetLatestCaptures(ctx, owner, storage.Filter{}, storage.Limit{})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 3 {
+				t.Fatalf("Expected one capture for each of three URLs; got %v", ids)
+			}
+			for i, id := range ids {
+				if latest[metas[i].URL] != id {
+					t.Errorf("Expected the latest capture %s of %s; got %s", latest[metas[i].URL], metas[i].URL, id)
+				}
+				if counts[i] != captures[metas[i].URL] {
+					t.Errorf("Expected %d captures of %s; got %d", captures[metas[i].URL], metas[i].URL, counts[i])
+				}
+			}
+
+			// The limit applies to URLs rather than documents
+			ids, _, _, err = cache.GetLatestCaptures(ctx, owner, storage.Filter{}, storage.Limit{Limit: 2})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 2 {
+				t.Errorf("Expected two URLs; got %v", ids)
+			}
+			ids, _, _, err = cache.GetLatestCaptures(ctx, owner, storage.Filter{}, storage.Limit{Offset: 2, Limit: 2})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 1 {
+				t.Errorf("Expected one URL after the offset; got %v", ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package gauntlet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestDirectFileAccess(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, direct := r.(storage.DirectFileAccesser)
+			t.Logf("direct file access: %v", direct)
+
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err := r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			attid, err := trns.NewAttachmentID(ctx, "png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			name, err := storage.AttachmentNameFromID(ctx, trns, attid)
+			if err != nil {
+				t.Fatal(err)
+			} else if name != "t"+attid+".png" {
+				t.Errorf("Expected attachment name t%s.png; got %s", attid, name)
+			}
+
+			for _, f := range []func() (io.WriteCloser, error){
+				func() (io.WriteCloser, error) { return trns.WriteRootFile(ctx, "document.bin") },
+				func() (io.WriteCloser, error) { return trns.WriteAttachment(ctx, name) },
+			} {
+				g, err := f()
+				if err != nil {
+					t.Fatal(err)
+				}
+				fmt.Fprintf(g, "contents of %s", id)
+				g.Close()
+			}
+			err = trns.Commit(ctx, "test direct file access")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, f := range []func() (io.ReadCloser, error){
+				func() (io.ReadCloser, error) { return storage.GetRootFile(ctx, r, id, "document.bin") },
+				func() (io.ReadCloser, error) { return storage.GetAttachment(ctx, r, id, name) },
+			} {
+				rd, err := f()
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := io.ReadAll(rd)
+				if string(b) != "contents of "+id {
+					t.Errorf("Unexpected contents '%s'", b)
+				}
+
+				// Files are served with support for byte ranges, so they should be able to seek
+				if rs, ok := rd.(io.ReadSeeker); !ok {
+					t.Errorf("Files of type %T cannot seek", rd)
+				} else {
+					size, err := rs.Seek(0, io.SeekEnd)
+					if err != nil || size != int64(len("contents of "+id)) {
+						t.Errorf("Seeking to the end returned size %d (%v)", size, err)
+					}
+					rs.Seek(-int64(len(id)), io.SeekEnd)
+					b, _ = io.ReadAll(rs)
+					if string(b) != id {
+						t.Errorf("Unexpected contents '%s' after seeking", b)
+					}
+				}
+				rd.Close()
+			}
+
+			if _, err := storage.GetAttachment(ctx, r, id, "t0000000000.png"); err == nil {
+				t.Errorf("Expected an error for a nonexistent attachment")
+			}
+			if _, err := storage.GetAttachment(ctx, r, id, "t"+attid+".svg"); err == nil {
+				t.Errorf("Attachments should not be found under a different extension")
+			}
+		})
+	}
+}
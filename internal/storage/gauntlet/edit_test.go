@@ -0,0 +1,98 @@
+package gauntlet
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestEditMeta(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err := r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta := storage.DocumentMeta{Title: "Untitled", URL: "https://example.org/", Status: storage.StatusStatic}
+			meta.Permissions.Owner = "alice"
+			err = storage.WriteMeta(ctx, trns, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = trns.Commit(ctx, "create document")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			date := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+			changed, err := storage.EditMeta(ctx, r, id, storage.MetaEdit{
+				Title:  "Example",
+				Author: "Alice",
+				Date:   date,
+				Permissions: storage.Permissions{
+					Owner:     "mallory",
+					ReadUsers: []string{"bob"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changed, []string{"title", "author", "date", "access lists"}) {
+				t.Errorf("Unexpected changed fields %q", changed)
+			}
+
+			trns, err = r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err = storage.ReadMeta(ctx, trns)
+			trns.Rollback()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Title != "Example" || meta.Author != "Alice" || !meta.Date.Equal(date) || meta.URL != "https://example.org/" {
+				t.Errorf("Metadata was not saved correctly: %+v", meta)
+			}
+			if meta.Permissions.Owner != "alice" || !reflect.DeepEqual(meta.Permissions.ReadUsers, []string{"bob"}) {
+				t.Errorf("Permissions were not saved correctly: %+v", meta.Permissions)
+			}
+
+			// Saving the same values again should not do anything
+			changed, err = storage.EditMeta(ctx, r, id, storage.MetaEdit{
+				Title:       "Example",
+				Author:      "Alice",
+				Date:        date,
+				Permissions: meta.Permissions,
+			})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(changed) != 0 {
+				t.Errorf("Expected no changes; got %q", changed)
+			}
+
+			if dh, ok := r.(storage.DocumentHistory); ok {
+				revs, err := dh.DocumentRevisions(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(revs) != 2 || revs[0].Message != "Change title, author, date and access lists" {
+					t.Errorf("Unexpected revisions %v", revs)
+				}
+			}
+		})
+	}
+}
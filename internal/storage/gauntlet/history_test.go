@@ -0,0 +1,169 @@
+package gauntlet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestDocumentHistory(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			dh, ok := r.(storage.DocumentHistory)
+			if !ok {
+				t.Skipf("storage backend %T does not keep a history", r)
+			}
+
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i <= 3; i++ {
+				trns, err := r.GetDocument(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				g, err := trns.WriteRootFile(ctx, "document.bin")
+				if err != nil {
+					t.Fatal(err)
+				}
+				fmt.Fprintf(g, "version %d", i)
+				g.Close()
+				err = trns.Commit(ctx, fmt.Sprintf("version %d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			revs, err := dh.DocumentRevisions(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(revs) != 3 {
+				t.Fatalf("Expected 3 revisions; got %v", revs)
+			}
+
+			if revs[0].Message != "version 3" || revs[2].Message != "version 1" {
+				t.Errorf("Expected log messages in reverse order; got %v", revs)
+			}
+
+			trns, err := dh.GetDocumentRevision(id, revs[2].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := trns.ReadRootFile(ctx, "document.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cts, _ := io.ReadAll(f)
+			f.Close()
+			if string(cts) != "version 1" {
+				t.Errorf("Expected first version; got '%s'", cts)
+			}
+			if _, err := trns.WriteRootFile(ctx, "document.bin"); err == nil {
+				t.Errorf("Historical revisions should be read-only")
+			}
+			trns.Rollback()
+
+			// Revisions of other documents can't be opened through this one
+			other, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err = r.GetDocument(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, err := trns.WriteRootFile(ctx, "document.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(g, "another document")
+			g.Close()
+			if err := trns.Commit(ctx, "another document"); err != nil {
+				t.Fatal(err)
+			}
+			otherRevs, err := dh.DocumentRevisions(ctx, other)
+			if err != nil || len(otherRevs) == 0 {
+				t.Fatalf("Expected a revision of the other document; got %v, %v", otherRevs, err)
+			}
+			for _, rev := range []string{otherRevs[0].ID, "not-a-revision", ""} {
+				if trns, err := dh.GetDocumentRevision(id, rev); err == nil {
+					trns.Rollback()
+					t.Errorf("Revision '%s' should not be found for document %s", rev, id)
+				}
+			}
+		})
+	}
+}
+
+func TestDocumentVersion(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := r.(storage.DocumentVersioner); !ok {
+				t.Skipf("storage backend %T does not report document versions", r)
+			}
+
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			versions := map[string]bool{}
+			for i := 1; i <= 3; i++ {
+				trns, err := r.GetDocument(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				g, err := trns.WriteRootFile(ctx, "document.bin")
+				if err != nil {
+					t.Fatal(err)
+				}
+				fmt.Fprintf(g, "version %d", i)
+				g.Close()
+				err = trns.Commit(ctx, fmt.Sprintf("version %d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				v := storage.DocumentVersion(ctx, r, id)
+				if v == "" || versions[v] {
+					t.Errorf("Commit %d did not get a new version; got '%s'", i, v)
+				}
+				versions[v] = true
+
+				// Reading a document doesn't change its version
+				trns, err = r.GetDocument(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f, err := trns.ReadRootFile(ctx, "document.bin")
+				if err == nil {
+					io.ReadAll(f)
+					f.Close()
+				}
+				trns.Rollback()
+				if w := storage.DocumentVersion(ctx, r, id); w != v {
+					t.Errorf("Reading a document changed its version from '%s' to '%s'", v, w)
+				}
+			}
+
+			if v := storage.DocumentVersion(ctx, r, "0123456789"); v != "" {
+				t.Errorf("Documents that don't exist should not have a version; got '%s'", v)
+			}
+		})
+	}
+}
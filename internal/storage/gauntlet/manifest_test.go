@@ -0,0 +1,154 @@
+package gauntlet
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestManifest(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err := r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer trns.Rollback()
+
+			m, err := storage.ReadManifest(ctx, trns)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(m.Attachments) != 0 {
+				t.Errorf("a new document has a manifest with %d attachments", len(m.Attachments))
+			}
+
+			// Register an upload before its contents arrive
+			err = storage.RecordAttachments(ctx, trns, storage.AttachmentOrigin{
+				Name:   "t0000000002.css",
+				Source: storage.SourceUploaded,
+				URL:    "https://example.org/style.css",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeAttachment(ctx, t, trns, "t0000000002.css", "body { color: red; }")
+			writeAttachment(ctx, t, trns, "t0000000001.png", "not really a png")
+			err = storage.RecordAttachments(ctx, trns, storage.AttachmentOrigin{
+				Name:   "t0000000002.css",
+				Source: storage.SourceUploaded,
+			}, storage.AttachmentOrigin{
+				Name:   "t0000000001.png",
+				Source: storage.SourceProxied,
+				URL:    "https://example.org/image.png",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err = storage.ReadManifest(ctx, trns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Attachments) != 2 || m.Attachments[0].Name != "t0000000001.png" {
+				t.Fatalf("unexpected manifest %+v", m.Attachments)
+			}
+			css, ok := m.Attachment("t0000000002.css")
+			if !ok {
+				t.Fatalf("stylesheet missing from manifest")
+			}
+			if css.URL != "https://example.org/style.css" {
+				t.Errorf("stylesheet URL was lost; got '%s'", css.URL)
+			}
+			if css.ContentType != "text/css" || css.Size != 20 || css.SHA256 != "5de625c36355cce7c1d5408826a0b21abfb49fb6c0e1f16c945a6f2aef38200c" {
+				t.Errorf("unexpected stylesheet origin %+v", css)
+			}
+
+			// Unknown fetch times are left out, rather than written as the zero time
+			if css.Fetched != nil {
+				t.Errorf("stylesheet has fetch time %v", css.Fetched)
+			}
+			f, err := trns.ReadRootFile(ctx, "manifest.xml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, _ := io.ReadAll(f)
+			f.Close()
+			if strings.Contains(string(raw), "Fetched") {
+				t.Errorf("manifest contains fetch times:\n%s", raw)
+			}
+		})
+	}
+}
+
+func TestManifestBuffer(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err := r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer trns.Rollback()
+			buf := &storage.ManifestBuffer{DocTransaction: trns}
+
+			writeAttachment(ctx, t, buf, "t0000000001.png", "not really a png")
+			writeAttachment(ctx, t, buf, "t0000000002.css", "body { color: red; }")
+			for _, name := range []string{"t0000000001.png", "t0000000002.css"} {
+				err = storage.RecordAttachments(ctx, buf, storage.AttachmentOrigin{
+					Name:   name,
+					Source: storage.SourceUploaded,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := trns.ReadRootFile(ctx, "manifest.xml"); err == nil {
+				t.Errorf("the manifest was written before the transaction was committed")
+			}
+			if len(buf.Pending) != 2 {
+				t.Errorf("expected 2 pending attachments; got %d", len(buf.Pending))
+			}
+
+			if err := buf.Commit(ctx, "test manifest buffer"); err != nil {
+				t.Fatal(err)
+			}
+			trns, err = r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := storage.ReadManifest(ctx, trns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Attachments) != 2 {
+				t.Fatalf("unexpected manifest %+v", m.Attachments)
+			}
+			if css, _ := m.Attachment("t0000000002.css"); css.Size != 20 {
+				t.Errorf("unexpected stylesheet origin %+v", css)
+			}
+		})
+	}
+}
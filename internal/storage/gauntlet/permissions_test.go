@@ -0,0 +1,99 @@
+package gauntlet
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestPermissions(t *testing.T) {
+	ctx := context.Background()
+
+	alice := storage.Principal{UserID: "alice"}
+	bob := storage.Principal{UserID: "bob", Groups: []string{"editors"}}
+	carol := storage.Principal{UserID: "carol", Groups: []string{"readers"}}
+	dave := storage.Principal{UserID: "dave"}
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache, err := storage.GetDocumentCache("index:"+path.Join(t.TempDir(), "index.json"), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err := r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta := storage.DocumentMeta{Title: "Shared document", Status: storage.StatusStatic}
+			meta.Permissions.Owner = alice.UserID
+			err = storage.WriteMeta(ctx, trns, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = trns.Commit(ctx, "create document")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = storage.SetPermissions(ctx, r, id, storage.Permissions{
+				Owner:       "mallory",
+				ReadGroups:  []string{"readers"},
+				WriteUsers:  []string{"eve"},
+				WriteGroups: []string{"editors"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			meta, err = cache.GetDocumentMeta(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Permissions.Owner != alice.UserID {
+				t.Errorf("SetPermissions should not change the owner; got '%s'", meta.Permissions.Owner)
+			}
+			if fmt.Sprint(meta.Permissions.ReadGroups, meta.Permissions.WriteUsers, meta.Permissions.WriteGroups) != "[readers] [eve] [editors]" {
+				t.Errorf("Permissions were not saved: %+v", meta.Permissions)
+			}
+
+			cases := []struct {
+				User      storage.Principal
+				Read      bool
+				Write     bool
+				ListCount int
+			}{
+				{alice, true, true, 1},
+				{bob, true, true, 1},
+				{carol, true, false, 1},
+				{dave, false, false, 0},
+				{storage.Principal{}, false, false, 0},
+			}
+			for _, c := range cases {
+				if meta.CanRead(c.User) != c.Read {
+					t.Errorf("User '%s': expected CanRead() to be %v", c.User.UserID, c.Read)
+				}
+				if meta.CanWrite(c.User) != c.Write {
+					t.Errorf("User '%s': expected CanWrite() to be %v", c.User.UserID, c.Write)
+				}
+				ids, _, err := cache.GetDocuments(ctx, c.User, storage.Filter{}, storage.Limit{Limit: 10})
+				if err != nil {
+					t.Fatal(err)
+				} else if len(ids) != c.ListCount {
+					t.Errorf("User '%s': expected %d documents; got %v", c.User.UserID, c.ListCount, ids)
+				}
+			}
+		})
+	}
+}
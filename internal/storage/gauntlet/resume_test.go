@@ -0,0 +1,124 @@
+package gauntlet
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestResumeTransaction(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			dir := t.TempDir()
+			r, err := storage.GetDocStore(scheme + ":" + dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err := r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = storage.WriteMeta(ctx, trns, storage.DocumentMeta{Title: "Draft", Status: storage.StatusDraft})
+			if err != nil {
+				t.Fatal(err)
+			}
+			attID, err := trns.NewAttachmentID(ctx, "css")
+			if err != nil {
+				t.Fatal(err)
+			}
+			attName := "t" + attID + ".css"
+			writeAttachment(ctx, t, trns, attName, "body { color: red; }")
+
+			rtrns, ok := trns.(storage.ResumableTransaction)
+			if !ok {
+				t.Fatalf("transactions of type %T cannot be resumed", trns)
+			}
+			token, err := rtrns.ResumeToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Open the store again, as if the server had restarted
+			r2, err := storage.GetDocStore(scheme + ":" + dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs, ok := r2.(storage.ResumableStore)
+			if !ok {
+				t.Fatalf("store of type %T cannot resume transactions", r2)
+			}
+
+			if _, err := rs.ResumeDocument(id, "bogus"); err == nil {
+				t.Errorf("resuming from an invalid token should fail")
+			}
+
+			trns2, err := rs.ResumeDocument(id, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := storage.ReadMeta(ctx, trns2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Title != "Draft" {
+				t.Errorf("resumed transaction has title '%s'", meta.Title)
+			}
+			f, err := trns2.ReadAttachment(ctx, attName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadAll(f)
+			f.Close()
+			if string(b) != "body { color: red; }" {
+				t.Errorf("resumed attachment has contents '%s'", b)
+			}
+
+			meta.Status = storage.StatusStatic
+			err = storage.WriteMeta(ctx, trns2, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = trns2.Commit(ctx, "finalize")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			trns3, err := r2.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer trns3.Rollback()
+			atts, err := trns3.ListAttachments(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(atts) != 1 || atts[0] != attName {
+				t.Errorf("expected attachment %s after commit; got %v", attName, atts)
+			}
+		})
+	}
+}
+
+func writeAttachment(ctx context.Context, t *testing.T, trns storage.DocTransaction, attName, contents string) {
+	g, err := trns.WriteAttachment(ctx, attName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.Write([]byte(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,129 @@
+package gauntlet
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestStagingCleanup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	r, err := storage.GetDocStore("fs:" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := r.NewDocumentID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trns, err := r.GetDocument(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := trns.WriteRootFile(ctx, "document.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(g, "published")
+	g.Close()
+	if err := trns.Commit(ctx, "test staging cleanup"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend a commit crashed between moving the old version away and
+	// moving the new version in, and that a deletion crashed halfway
+	staging := path.Join(dir, ".staging")
+	old := path.Join(staging, "g"+id+"-next-123-old")
+	next := path.Join(staging, "g"+id+"-next-123")
+	deleted := path.Join(staging, "g"+id+"-deleted-456")
+	scratch := path.Join(staging, "g"+id+"-789")
+	if err := os.Rename(path.Join(dir, "g"+id), old); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{next, deleted, scratch} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	longAgo := time.Now().Add(-24 * time.Hour)
+	for _, d := range []string{old, next, deleted, scratch} {
+		os.Chtimes(d, longAgo, longAgo)
+	}
+
+	r, err = storage.GetDocStore("fs:" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := storage.GetRootFile(ctx, r, id, "document.bin")
+	if err != nil {
+		t.Fatalf("the old version of the document was not restored: %v", err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	if string(b) != "published" {
+		t.Errorf("unexpected contents '%s'", b)
+	}
+
+	for _, d := range []string{old, next, deleted} {
+		if _, err := os.Stat(d); err == nil {
+			t.Errorf("stale directory %s was not removed", path.Base(d))
+		}
+	}
+	if _, err := os.Stat(scratch); err != nil {
+		t.Errorf("scratch directories should be kept, as their drafts may be resumed")
+	}
+}
+
+func TestReservedDocumentID(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	r, err := storage.GetDocStore("fs:" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := r.NewDocumentID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A transaction that only reads doesn't own the new document
+	trns, err := r.GetDocument(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := trns.ReadRootFile(ctx, "document.bin"); err == nil {
+		f.Close()
+	}
+	if err := trns.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dir, "g"+id)); err != nil {
+		t.Errorf("a read-only rollback removed the reserved document directory: %v", err)
+	}
+
+	// A transaction that was meant to create it cleans up after itself
+	trns, err = r.GetDocument(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := trns.WriteRootFile(ctx, "document.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(g, "aborted")
+	g.Close()
+	if err := trns.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dir, "g"+id)); err == nil {
+		t.Errorf("rolling back a new document left its directory behind")
+	}
+}
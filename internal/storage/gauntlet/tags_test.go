@@ -0,0 +1,106 @@
+package gauntlet
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	alice := storage.Principal{UserID: "alice"}
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache, err := storage.GetDocumentCache("index:"+path.Join(t.TempDir(), "index.json"), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tags := [][]string{
+				{"Recipes", " soup ", "recipes"},
+				{"recipes", "cake"},
+				nil,
+			}
+			ids := make([]string, len(tags))
+			for i, tt := range tags {
+				ids[i], err = r.NewDocumentID(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				trns, err := r.GetDocument(ids[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				meta := storage.DocumentMeta{Title: "Document", Status: storage.StatusStatic}
+				meta.Permissions.Owner = alice.UserID
+				err = storage.WriteMeta(ctx, trns, meta)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = trns.Commit(ctx, "create document")
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = storage.SetTags(ctx, r, ids[i], tt)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			meta, err := cache.GetDocumentMeta(ctx, ids[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(meta.Tags, []string{"recipes", "soup"}) {
+				t.Errorf("Tags were not normalized; got %q", meta.Tags)
+			}
+
+			filterCases := []struct {
+				Tags     []string
+				Expected int
+			}{
+				{nil, 3},
+				{[]string{"recipes"}, 2},
+				{[]string{"recipes", "cake"}, 1},
+				{[]string{"soup", "cake"}, 0},
+			}
+			for _, c := range filterCases {
+				found, _, err := cache.GetDocuments(ctx, alice, storage.Filter{Tags: c.Tags}, storage.Limit{Limit: 10})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(found) != c.Expected {
+					t.Errorf("Filter %q: expected %d documents; got %d", c.Tags, c.Expected, len(found))
+				}
+			}
+
+			counts, err := cache.GetTags(ctx, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []storage.TagCount{
+				{Tag: "cake", Count: 1},
+				{Tag: "recipes", Count: 2},
+				{Tag: "soup", Count: 1},
+			}
+			if !reflect.DeepEqual(counts, expected) {
+				t.Errorf("Expected tag counts %v; got %v", expected, counts)
+			}
+
+			counts, err = cache.GetTags(ctx, storage.Principal{UserID: "bob"})
+			if err != nil {
+				t.Fatal(err)
+			} else if len(counts) != 0 {
+				t.Errorf("Tags of unreadable documents should not be counted; got %v", counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package gauntlet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestTransactions(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			writeFile := func(g io.WriteCloser, err error) func(string) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return func(contents string) {
+					fmt.Fprint(g, contents)
+					g.Close()
+				}
+			}
+			readFile := func(f io.ReadCloser, err error) string {
+				if err != nil {
+					return fmt.Sprintf("error: %v", err)
+				}
+				defer f.Close()
+				b, _ := io.ReadAll(f)
+				return string(b)
+			}
+			checkDocument := func(id, document string, attachments ...string) {
+				t.Helper()
+				trns, err := r.GetDocument(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer trns.Rollback()
+
+				if s := readFile(trns.ReadRootFile(ctx, "document.bin")); s != document {
+					t.Errorf("Expected document '%s'; got '%s'", document, s)
+				}
+				atts, err := trns.ListAttachments(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprint(atts) != fmt.Sprint(attachments) {
+					t.Errorf("Expected attachments %v; got %v", attachments, atts)
+				}
+			}
+
+			// A new document that gets rolled back should not exist afterwards
+			id, err := r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err := r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(trns.WriteRootFile(ctx, "document.bin"))("aborted")
+			err = trns.Rollback()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids, err := r.DocumentIDs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ids) != 0 {
+				t.Errorf("Expected no documents after rollback; got %v", ids)
+			}
+
+			// Create a document
+			id, err = r.NewDocumentID(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trns, err = r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(trns.WriteRootFile(ctx, "document.bin"))("version 1")
+			writeFile(trns.WriteAttachment(ctx, "t0000000001.css"))("p { color: red; }")
+			err = trns.Commit(ctx, "version 1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDocument(id, "version 1", "t0000000001.css")
+
+			// Modify it, but roll back
+			trns, err = r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(trns.WriteRootFile(ctx, "document.bin"))("version 2")
+			writeFile(trns.WriteAttachment(ctx, "t0000000002.css"))("p { color: blue; }")
+			err = trns.DeleteAttachment(ctx, "t0000000001.css")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := readFile(trns.ReadRootFile(ctx, "document.bin")); s != "version 2" {
+				t.Errorf("A transaction should see its own changes; got '%s'", s)
+			}
+			checkDocument(id, "version 1", "t0000000001.css")
+			err = trns.Rollback()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDocument(id, "version 1", "t0000000001.css")
+
+			// Modify it, and commit
+			trns, err = r.GetDocument(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(trns.WriteRootFile(ctx, "document.bin"))("version 3")
+			writeFile(trns.WriteAttachment(ctx, "t0000000003.css"))("p { color: green; }")
+			err = trns.DeleteAttachment(ctx, "t0000000001.css")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDocument(id, "version 1", "t0000000001.css")
+			err = trns.Commit(ctx, "version 3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkDocument(id, "version 3", "t0000000003.css")
+		})
+	}
+}
+
+func TestNewDocumentConflict(t *testing.T) {
+	ctx := context.Background()
+
+	// The fs backend publishes whichever transaction commits last
+	r, err := storage.GetDocStore("git:" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := r.NewDocumentID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var trns [2]storage.DocTransaction
+	for i := range trns {
+		trns[i], err = r.GetDocument(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer trns[i].Rollback()
+		g, err := trns[i].WriteRootFile(ctx, "document.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(g, "version %d", i+1)
+		g.Close()
+	}
+
+	if err := trns[0].Commit(ctx, "version 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := trns[1].Commit(ctx, "version 2"); err == nil {
+		t.Errorf("a second transaction creating the same document overwrote the first")
+	}
+
+	f, err := storage.GetRootFile(ctx, r, id, "document.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	if string(b) != "version 1" {
+		t.Errorf("expected the first version; got '%s'", b)
+	}
+}
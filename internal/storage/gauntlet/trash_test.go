@@ -0,0 +1,119 @@
+package gauntlet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+
+	for _, scheme := range gauntletSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			r, err := storage.GetDocStore(scheme + ":" + t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newDoc := func(owner string) string {
+				id, err := r.NewDocumentID(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				trns, err := r.GetDocument(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				meta := storage.DocumentMeta{Title: "Document " + id, Status: storage.StatusStatic}
+				meta.Permissions.Owner = owner
+				err = storage.WriteMeta(ctx, trns, meta)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = trns.Commit(ctx, "create document")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return id
+			}
+			readMeta := func(id string) storage.DocumentMeta {
+				trns, err := r.GetDocument(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer trns.Rollback()
+				meta, err := storage.ReadMeta(ctx, trns)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return meta
+			}
+			countDocs := func() int {
+				ids, err := r.DocumentIDs(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return len(ids)
+			}
+
+			alice, bob := newDoc("alice"), newDoc("bob")
+
+			// Trash and restore
+			err = storage.TrashDocument(ctx, r, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta := readMeta(alice); !meta.Trashed() || meta.TrashDate.IsZero() {
+				t.Errorf("Document should be in the trash; got status '%s'", meta.Status)
+			} else if meta.CanRead(storage.Principal{UserID: "bob"}) || !meta.CanRead(storage.Principal{UserID: "alice"}) {
+				t.Errorf("Only the owner should be able to see trashed documents")
+			}
+			err = storage.RestoreDocument(ctx, r, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta := readMeta(alice); meta.Status != storage.StatusStatic || !meta.TrashDate.IsZero() {
+				t.Errorf("Document should have been restored; got status '%s'", meta.Status)
+			}
+			if err := storage.RestoreDocument(ctx, r, alice); err != storage.ErrNotTrashed {
+				t.Errorf("Expected ErrNotTrashed; got %v", err)
+			}
+
+			// Documents only get purged after the retention period
+			storage.TrashDocument(ctx, r, alice)
+			storage.TrashDocument(ctx, r, bob)
+			deleted, err := storage.EmptyTrash(ctx, r, "", time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			} else if len(deleted) != 0 {
+				t.Errorf("Expected no deleted documents; got %v", deleted)
+			}
+
+			// Emptying a user's trash leaves other users' documents alone
+			deleted, err = storage.EmptyTrash(ctx, r, "alice", time.Now().Add(time.Second))
+			if err != nil {
+				t.Fatal(err)
+			} else if len(deleted) != 1 || deleted[0] != alice {
+				t.Errorf("Expected only document %s to be deleted; got %v", alice, deleted)
+			}
+			if n := countDocs(); n != 1 {
+				t.Errorf("Expected 1 remaining document; got %d", n)
+			}
+
+			// Deleting documents
+			err = r.DeleteDocument(ctx, bob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := countDocs(); n != 0 {
+				t.Errorf("Expected no remaining documents; got %d", n)
+			}
+			if err := r.DeleteDocument(ctx, bob); err == nil {
+				t.Errorf("Expected an error deleting a nonexistent document")
+			}
+		})
+	}
+}
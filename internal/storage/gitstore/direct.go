@@ -0,0 +1,147 @@
+package gitstore
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+
+	gitpl "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+)
+
+// documentTree returns the tree of a document's directory at the tip of its branch
+func (g *repo) documentTree(docID string) (*object.Tree, error) {
+	ref, err := g.repository.Reference(gitpl.NewBranchReferenceName("g"+docID), false)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+
+	cmt, err := g.repository.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting commit obj")
+	}
+	tree, err := cmt.Tree()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting tree obj")
+	}
+
+	docTree, err := tree.Tree("g" + docID)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	return docTree, nil
+}
+
+// GetRootFile reads a root file from the current version of a document, without starting a transaction
+func (g *repo) GetRootFile(ctx context.Context, docID, name string) (io.ReadCloser, error) {
+	tree, err := g.documentTree(docID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := tree.File(name)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	return &blobReader{blob: &f.Blob}, nil
+}
+
+// GetAttachment reads an attachment from the current version of a document, without starting a transaction
+func (g *repo) GetAttachment(ctx context.Context, docID, name string) (io.ReadCloser, error) {
+	tree, err := g.documentTree(docID)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(name, "/") {
+		return nil, fs.ErrNotExist
+	}
+	f, err := tree.File("att/" + name)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	return &blobReader{blob: &f.Blob}, nil
+}
+
+// AttachmentNameFromID finds the file name for an attachment ID, using the tree listing
+func (t *transaction) AttachmentNameFromID(ctx context.Context, attID string) (string, error) {
+	names, err := t.ListAttachments(ctx)
+	if err != nil {
+		return "", err
+	}
+	return findAttachment(names, attID)
+}
+
+func findAttachment(names []string, attID string) (string, error) {
+	prefix := "t" + attID + "."
+	for _, n := range names {
+		if strings.HasPrefix(n, prefix) {
+			return n, nil
+		}
+	}
+	return "", fs.ErrNotExist
+}
+
+// A blobReader reads a blob from the repository. Blobs are stored compressed
+// and cannot seek, so seeking back reopens the blob and skips ahead. As the
+// size of a blob is known, seeking to the end to find it is free.
+type blobReader struct {
+	blob *object.Blob
+	r    io.ReadCloser
+	pos  int64
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	if b.r == nil {
+		r, err := b.blob.Reader()
+		if err != nil {
+			return 0, err
+		}
+		b.r = r
+		if _, err := io.CopyN(io.Discard, b.r, b.pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	n, err := b.r.Read(p)
+	b.pos += int64(n)
+	return n, err
+}
+
+func (b *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += b.blob.Size
+	}
+	if offset < 0 {
+		return b.pos, errors.New("negative position")
+	}
+
+	if b.r != nil && offset >= b.pos {
+		n, err := io.CopyN(io.Discard, b.r, offset-b.pos)
+		b.pos += n
+		if err != nil && err != io.EOF {
+			return b.pos, err
+		}
+	} else if b.r != nil {
+		b.r.Close()
+		b.r = nil
+	}
+	b.pos = offset
+	return offset, nil
+}
+
+func (b *blobReader) Close() error {
+	if b.r == nil {
+		return nil
+	}
+	err := b.r.Close()
+	b.r = nil
+	return err
+}
+
+// ETag identifies the contents of this blob by its hash
+func (b *blobReader) ETag() string {
+	return b.blob.Hash.String()
+}
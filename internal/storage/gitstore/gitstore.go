@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
 	"path"
+	"sort"
+	"strings"
+	"sync"
 
-	"github.com/go-git/go-billy/v5/memfs"
 	git "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	gitpl "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	gitst "github.com/go-git/go-git/v5/plumbing/storer"
-	"github.com/go-git/go-git/v5/storage/memory"
+	gitstorage "github.com/go-git/go-git/v5/storage"
 	"github.com/pkg/errors"
 	"github.com/thijzert/doc-hoarder/internal/storage"
 )
@@ -37,8 +42,13 @@ func init() {
 }
 
 type repo struct {
+	storage.ChangeHooks
 	path       string
 	repository *git.Repository
+
+	// newBranches makes sure only one transaction at a time creates the
+	// branch for a new document
+	newBranches sync.Mutex
 }
 
 func (g *repo) Init(ctx context.Context) error {
@@ -74,122 +84,34 @@ func (g *repo) Init(ctx context.Context) error {
 		return errors.Wrapf(err, "failed to create repository")
 	}
 
-	s := memory.NewStorage()
-	fs := memfs.New()
-
-	wcopy, err := git.Init(s, fs)
-	if err != nil {
-		return errors.Wrapf(err, "failed to create working copy")
-	}
-	err = wcopy.SetConfig(conf)
-	if err != nil {
-		return errors.Wrapf(err, "failed to create repository")
-	}
-	wt, err := wcopy.Worktree()
+	// Create an initial commit on the main branch, containing only a README
+	readme, err := g.writeBlob(strings.NewReader("Hello, world\n"))
 	if err != nil {
-		return errors.Wrapf(err, "failed to create working copy")
+		return errors.Wrapf(err, "failed to create README")
 	}
-	_, err = wcopy.CreateRemote(&config.RemoteConfig{
-		Name: "origin",
-		URLs: []string{g.path},
+	tree, err := g.writeTree([]object.TreeEntry{
+		{Name: "README.md", Mode: filemode.Regular, Hash: readme},
 	})
-	if err != nil {
-		return errors.Wrapf(err, "failed to add origin in working copy")
-	}
-	//err = wcopy.FetchContext(ctx, &git.FetchOptions{
-	//	RemoteName: "origin",
-	//	Depth:      2,
-	//})
-	//if err != nil {
-	//	return errors.Wrapf(err, "failed to fetch origin in working copy")
-	//}
-
-	mainRef := gitpl.NewBranchReferenceName(mainBranch)
-
-	err = wcopy.CreateBranch(&config.Branch{Name: mainBranch})
-	if err != nil {
-		return errors.Wrapf(err, "failed to create branch in working copy")
-	}
-	//err = wt.Checkout(&git.CheckoutOptions{
-	//	Branch: mainRef,
-	//})
-	//if err != nil {
-	//	return errors.Wrapf(err, "failed to change branch in working copy")
-	//}
-
-	f, err := fs.Create("README.md")
 	if err != nil {
 		return errors.Wrapf(err, "failed to create README")
 	}
-	f.Write([]byte("Hello, world\n"))
-	f.Close()
-
-	_, err = wt.Add("README.md")
-	if err != nil {
-		return errors.Wrapf(err, "failed to add README")
-	}
-	mainHash, err := wt.Commit("initial commit", &git.CommitOptions{})
+	mainHash, err := g.writeCommit(tree, gitpl.ZeroHash, "initial commit")
 	if err != nil {
 		return errors.Wrapf(err, "failed to commit README")
 	}
 
-	if mainBranch != "master" {
-		// For some reason it's impossible to change the branch in an empty repository
-		err = wt.Checkout(&git.CheckoutOptions{
-			Branch: mainRef,
-			Create: true,
-		})
-		if err != nil {
-			return errors.Wrapf(err, "failed to create branch in working copy")
-		}
-	}
-
-	err = wcopy.Push(&git.PushOptions{
-		RemoteName: "origin",
-		RefSpecs:   []config.RefSpec{config.RefSpec(string(mainRef) + ":" + string(mainRef))},
-	})
+	mainRef := gitpl.NewBranchReferenceName(mainBranch)
+	err = g.repository.Storer.SetReference(gitpl.NewHashReference(mainRef, mainHash))
 	if err != nil {
-		return errors.Wrapf(err, "failed to push results")
+		return errors.Wrapf(err, "failed to create main branch")
 	}
-
-	head := gitpl.NewHashReference(gitpl.HEAD, mainHash)
-	err = g.repository.Storer.SetReference(head)
+	err = g.repository.Storer.SetReference(gitpl.NewSymbolicReference(gitpl.HEAD, mainRef))
 	if err != nil {
 		return errors.Wrapf(err, "failed to update HEAD")
 	}
 	return nil
 }
 
-func (g *repo) getFileFromBranch(ctx context.Context, branch, filename string) (io.ReadCloser, error) {
-	ref, err := g.repository.Reference(gitpl.NewBranchReferenceName(branch), false)
-	if err != nil {
-		return nil, fs.ErrNotExist
-	}
-
-	cmt, err := g.repository.CommitObject(ref.Hash())
-	if err != nil {
-		return nil, errors.Wrap(err, "error getting commit obj")
-	}
-
-	tree, err := g.repository.TreeObject(cmt.TreeHash)
-	if err != nil {
-		return nil, errors.Wrap(err, "error getting tree obj")
-	}
-
-	for _, ent := range tree.Entries {
-		if ent.Name == filename {
-			blob, err := g.repository.BlobObject(ent.Hash)
-			if err != nil {
-				return nil, errors.Wrap(err, "error getting blob")
-			}
-
-			return blob.Reader()
-		}
-	}
-
-	return nil, fs.ErrNotExist
-}
-
 // DocumentIDs lists all IDs for documents in this store
 func (g *repo) DocumentIDs(ctx context.Context) ([]string, error) {
 	branches, err := g.repository.Branches()
@@ -218,6 +140,15 @@ func (g *repo) DocumentIDs(ctx context.Context) ([]string, error) {
 	return rv, err
 }
 
+// DocumentVersion returns the commit a document's branch points to
+func (g *repo) DocumentVersion(ctx context.Context, id string) (string, error) {
+	ref, err := g.repository.Reference(gitpl.NewBranchReferenceName("g"+id), false)
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
 // NewDocumentID generates a new document ID that is not yet present in this store
 func (g *repo) NewDocumentID(ctx context.Context) (string, error) {
 	rv := ""
@@ -244,99 +175,234 @@ func (g *repo) NewDocumentID(ctx context.Context) (string, error) {
 	return rv, err
 }
 
+// discardBranch removes the configuration NewDocumentID created for a branch,
+// if the document was never committed
+func (g *repo) discardBranch(name string) {
+	_, err := g.repository.Reference(gitpl.NewBranchReferenceName(name), false)
+	if !errors.Is(err, gitpl.ErrReferenceNotFound) {
+		return
+	}
+	g.repository.DeleteBranch(name)
+}
+
 // GetDocument starts a transaction for a document ID
 func (g *repo) GetDocument(id string) (storage.DocTransaction, error) {
-	ctx := context.Background()
-	cl, err := git.CloneContext(ctx, memory.NewStorage(), memfs.New(), &git.CloneOptions{
-		URL: g.path,
-	})
-	if err != nil {
-		return nil, err
+	rv := &transaction{
+		repo:    g,
+		dir:     "g" + id,
+		staged:  make(map[string]gitpl.Hash),
+		deleted: make(map[string]bool),
 	}
 
-	brref := gitpl.NewBranchReferenceName("g" + id)
+	// New documents start out as a branch off main
+	brref := gitpl.NewBranchReferenceName(rv.dir)
 	b, err := g.repository.Reference(brref, false)
 	if errors.Is(err, gitpl.ErrReferenceNotFound) {
 		b, err = g.repository.Reference(gitpl.NewBranchReferenceName(mainBranch), false)
+	} else if err == nil {
+		rv.ref = b
 	}
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot get id")
 	}
 
-	wt, err := cl.Worktree()
+	rv.base = b.Hash()
+	cmt, err := g.repository.CommitObject(rv.base)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting commit obj")
+	}
+	rv.tree, err = cmt.Tree()
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot get worktree")
+		return nil, errors.Wrap(err, "error getting tree obj")
 	}
 
-	gco := &git.CheckoutOptions{
-		Branch: brref,
-		Create: true,
-		Hash:   b.Hash(),
+	return rv, nil
+}
+
+// ResumeDocument reopens a transaction from its resume token. The blobs
+// written so far are already in the repository, so the token only has to
+// record where they belong.
+func (g *repo) ResumeDocument(id, token string) (storage.DocTransaction, error) {
+	var state resumeState
+	if err := storage.DecodeResumeToken(token, &state); err != nil {
+		return nil, err
+	}
+
+	rv := &transaction{
+		repo:    g,
+		dir:     "g" + id,
+		base:    gitpl.NewHash(state.Base),
+		staged:  make(map[string]gitpl.Hash),
+		deleted: make(map[string]bool),
 	}
-	err = wt.Checkout(gco)
+	if state.Branch {
+		rv.ref = gitpl.NewHashReference(gitpl.NewBranchReferenceName(rv.dir), rv.base)
+	}
+
+	cmt, err := g.repository.CommitObject(rv.base)
+	if err != nil {
+		return nil, storage.ErrInvalidResumeToken
+	}
+	rv.tree, err = cmt.Tree()
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot switch branches")
+		return nil, errors.Wrap(err, "error getting tree obj")
 	}
 
-	return &transaction{
-		repo:  g,
-		clone: cl,
-		dir:   "g" + id,
-	}, nil
+	for name, h := range state.Staged {
+		hash := gitpl.NewHash(h)
+		if _, err := g.repository.BlobObject(hash); err != nil {
+			return nil, storage.ErrInvalidResumeToken
+		}
+		rv.staged[name] = hash
+	}
+	for _, name := range state.Deleted {
+		rv.deleted[name] = true
+	}
+
+	return rv, nil
+}
+
+// DeleteDocument removes the branch for a document. The objects it contained
+// are left for git's garbage collection to clean up.
+func (g *repo) DeleteDocument(ctx context.Context, id string) error {
+	brref := gitpl.NewBranchReferenceName("g" + id)
+	_, err := g.repository.Reference(brref, false)
+	if errors.Is(err, gitpl.ErrReferenceNotFound) {
+		return &fs.PathError{Op: "delete", Path: "g" + id, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return err
+	}
+
+	err = g.repository.Storer.RemoveReference(brref)
+	if err != nil {
+		return errors.Wrap(err, "cannot delete branch")
+	}
+
+	g.Changed(ctx, id)
+	return nil
 }
 
+// A transaction writes new blobs straight into the repository. On commit, the
+// trees and commit object are created for the document's branch.
 type transaction struct {
-	repo  *repo
-	clone *git.Repository
-	dir   string
+	repo *repo
+	dir  string
+
+	// ref is the document's branch at the start of this transaction, or nil for new documents
+	ref  *gitpl.Reference
+	base gitpl.Hash
+	tree *object.Tree
+
+	// staged contains the blobs for all files written during this transaction, relative to dir
+	staged  map[string]gitpl.Hash
+	deleted map[string]bool
+	closed  bool
 }
 
+// ErrConflict is returned if a document's branch was updated by another transaction
+var ErrConflict = errors.New("document was modified by another transaction")
+
 func (t *transaction) DocumentID() string {
 	return t.dir[1:]
 }
 
-func (t *transaction) ReadRootFile(ctx context.Context, name string) (io.ReadCloser, error) {
-	wt, err := t.clone.Worktree()
+func (t *transaction) open(name string) (io.ReadCloser, error) {
+	if t.closed {
+		return nil, storage.ErrTransactionClosed
+	}
+	if t.deleted[name] {
+		return nil, fs.ErrNotExist
+	}
+	if h, ok := t.staged[name]; ok {
+		blob, err := t.repo.repository.BlobObject(h)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting blob")
+		}
+		return &blobReader{blob: blob}, nil
+	}
+
+	f, err := t.tree.File(path.Join(t.dir, name))
 	if err != nil {
-		return nil, err
+		return nil, fs.ErrNotExist
 	}
-	return wt.Filesystem.Open(path.Join(t.dir, name))
+	return &blobReader{blob: &f.Blob}, nil
 }
-func (t *transaction) WriteRootFile(ctx context.Context, name string) (io.WriteCloser, error) {
-	wt, err := t.clone.Worktree()
-	if err != nil {
-		return nil, err
+
+func (t *transaction) create(name string) (io.WriteCloser, error) {
+	if t.closed {
+		return nil, storage.ErrTransactionClosed
 	}
 
-	filename := path.Join(t.dir, name)
-	rv, err := wt.Filesystem.Create(filename)
+	f, err := spoolFile()
 	if err != nil {
 		return nil, err
 	}
+	return &blobWriter{
+		File: f,
+		t:    t,
+		name: name,
+	}, nil
+}
+
+// A blobWriter spools a file to disk, and stores it in the repository as a
+// blob once it is closed
+type blobWriter struct {
+	*os.File
+	t    *transaction
+	name string
+}
 
-	_, err = wt.Add(filename)
+func (w *blobWriter) Close() error {
+	defer removeSpool(w.File)
+	h, err := w.t.repo.storeBlob(w.File)
 	if err != nil {
-		rv.Close()
-		return nil, err
+		return err
 	}
+	w.t.staged[w.name] = h
+	delete(w.t.deleted, w.name)
+	return nil
+}
 
-	return rv, nil
+func (t *transaction) exists(name string) bool {
+	if t.deleted[name] {
+		return false
+	}
+	if _, ok := t.staged[name]; ok {
+		return true
+	}
+	_, err := t.tree.FindEntry(path.Join(t.dir, name))
+	return err == nil
+}
+
+func (t *transaction) ReadRootFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	return t.open(name)
+}
+func (t *transaction) WriteRootFile(ctx context.Context, name string) (io.WriteCloser, error) {
+	return t.create(name)
 }
 
 func (t *transaction) ListAttachments(context.Context) ([]string, error) {
-	wt, err := t.clone.Worktree()
-	if err != nil {
-		return nil, err
+	if t.closed {
+		return nil, storage.ErrTransactionClosed
 	}
-	fileInfos, err := wt.Filesystem.ReadDir(path.Join(t.dir, "att"))
-	if err != nil && !errors.Is(err, fs.ErrNotExist) {
-		return nil, err
+
+	names := make(map[string]bool)
+	if attTree, err := t.tree.Tree(path.Join(t.dir, "att")); err == nil {
+		for _, ent := range attTree.Entries {
+			if ent.Mode.IsFile() && !t.deleted["att/"+ent.Name] {
+				names[ent.Name] = true
+			}
+		}
+	}
+	for name := range t.staged {
+		if path.Dir(name) == "att" {
+			names[path.Base(name)] = true
+		}
 	}
 
 	rv := []string{}
-	for _, fi := range fileInfos {
-		n := fi.Name()
-		if fi.IsDir() || len(n) < 12 || n[0] != 't' || n[11] != '.' {
+	for n := range names {
+		if len(n) < 12 || n[0] != 't' || n[11] != '.' {
 			continue
 		}
 
@@ -347,87 +413,193 @@ func (t *transaction) ListAttachments(context.Context) ([]string, error) {
 		}
 		rv = append(rv, n)
 	}
+	sort.Strings(rv)
 
 	return rv, nil
 }
 func (t *transaction) ReadAttachment(ctx context.Context, name string) (io.ReadCloser, error) {
-	wt, err := t.clone.Worktree()
-	if err != nil {
-		return nil, err
-	}
-	return wt.Filesystem.Open(path.Join(t.dir, "att", name))
+	return t.open(path.Join("att", name))
 }
 func (t *transaction) NewAttachmentID(ctx context.Context, ext string) (string, error) {
-	wt, err := t.clone.Worktree()
-	if err != nil {
-		return "", err
-	}
-	wt.Filesystem.MkdirAll(path.Join(t.dir, "att"), 0755)
-
 	var rv string
 	for ctx.Err() == nil {
 		rv = storage.NewDocumentID()
-		fp := path.Join(t.dir, "att", "t"+rv+"."+ext)
-		_, err := wt.Filesystem.Stat(fp)
-		if err != nil {
-			if errors.Is(err, fs.ErrNotExist) {
-				g, err := wt.Filesystem.Create(fp)
-				if err == nil {
-					g.Close()
-					wt.Add(fp)
-					return rv, nil
-				}
-			} else {
+		name := path.Join("att", "t"+rv+"."+ext)
+		if !t.exists(name) {
+			g, err := t.create(name)
+			if err != nil {
 				return "", err
 			}
+			return rv, g.Close()
 		}
 	}
 	return "", ctx.Err()
 }
 func (t *transaction) WriteAttachment(ctx context.Context, name string) (io.WriteCloser, error) {
-	wt, err := t.clone.Worktree()
-	if err != nil {
-		return nil, err
-	}
-	wt.Filesystem.MkdirAll(path.Join(t.dir, "att"), 0755)
-	fp := path.Join(t.dir, "att", name)
-	rv, err := wt.Filesystem.Create(fp)
-	wt.Add(fp)
-	return rv, err
+	return t.create(path.Join("att", name))
 }
 func (t *transaction) DeleteAttachment(ctx context.Context, name string) error {
-	wt, err := t.clone.Worktree()
-	if err != nil {
-		return err
+	if t.closed {
+		return storage.ErrTransactionClosed
+	}
+	name = path.Join("att", name)
+	if !t.exists(name) {
+		return fs.ErrNotExist
+	}
+	delete(t.staged, name)
+	t.deleted[name] = true
+	return nil
+}
+
+type resumeState struct {
+	Base    string
+	Branch  bool              `json:",omitempty"`
+	Staged  map[string]string `json:",omitempty"`
+	Deleted []string          `json:",omitempty"`
+}
+
+// ResumeToken returns a token from which this transaction can be resumed
+func (t *transaction) ResumeToken() (string, error) {
+	if t.closed {
+		return "", storage.ErrTransactionClosed
+	}
+
+	state := resumeState{
+		Base:   t.base.String(),
+		Branch: t.ref != nil,
+		Staged: make(map[string]string, len(t.staged)),
+	}
+	for name, h := range t.staged {
+		state.Staged[name] = h.String()
 	}
-	_, err = wt.Remove(path.Join(t.dir, "att", name))
-	return err
+	for name := range t.deleted {
+		state.Deleted = append(state.Deleted, name)
+	}
+	sort.Strings(state.Deleted)
+
+	return storage.EncodeResumeToken(state)
 }
 
 func (t *transaction) Commit(ctx context.Context, logMessage string) error {
-	wt, err := t.clone.Worktree()
+	if t.closed {
+		return storage.ErrTransactionClosed
+	}
+
+	docTree, err := t.buildDocumentTree()
 	if err != nil {
 		return err
 	}
 
-	opts := git.CommitOptions{
-		All: true,
+	// Replace the document's directory in the root tree
+	entries := []object.TreeEntry{}
+	for _, ent := range t.tree.Entries {
+		if ent.Name != t.dir {
+			entries = append(entries, ent)
+		}
 	}
-	_, err = wt.Commit(logMessage, &opts)
+	entries = append(entries, object.TreeEntry{Name: t.dir, Mode: filemode.Dir, Hash: docTree})
+	root, err := t.repo.writeTree(entries)
 	if err != nil {
 		return err
 	}
 
-	ref := gitpl.NewBranchReferenceName(t.dir)
-	push := git.PushOptions{
-		RemoteName: "origin",
-		RefSpecs: []config.RefSpec{
-			config.RefSpec(string(ref) + ":" + string(ref)),
-		},
+	cmt, err := t.repo.writeCommit(root, t.base, logMessage)
+	if err != nil {
+		return err
+	}
+
+	newRef := gitpl.NewHashReference(gitpl.NewBranchReferenceName(t.dir), cmt)
+	if t.ref == nil {
+		err = t.repo.createReference(newRef)
+	} else {
+		err = t.repo.repository.Storer.CheckAndSetReference(newRef, t.ref)
+	}
+	if err != nil {
+		if errors.Is(err, gitstorage.ErrReferenceHasChanged) {
+			return ErrConflict
+		}
+		return err
 	}
-	return t.clone.PushContext(ctx, &push)
+	t.closed = true
+
+	t.repo.Changed(ctx, t.DocumentID())
+	return nil
 }
+
+// createReference sets a reference that should not exist yet. Without an old
+// reference to compare against, CheckAndSetReference would overwrite a branch
+// that another transaction created in the meantime.
+func (g *repo) createReference(ref *gitpl.Reference) error {
+	g.newBranches.Lock()
+	defer g.newBranches.Unlock()
+
+	_, err := g.repository.Reference(ref.Name(), false)
+	if err == nil {
+		return gitstorage.ErrReferenceHasChanged
+	} else if !errors.Is(err, gitpl.ErrReferenceNotFound) {
+		return err
+	}
+	return g.repository.Storer.SetReference(ref)
+}
+
+// buildDocumentTree writes the tree objects for the new version of the document
+func (t *transaction) buildDocumentTree() (gitpl.Hash, error) {
+	var rootEntries, attEntries []object.TreeEntry
+	if docTree, err := t.tree.Tree(t.dir); err == nil {
+		rootEntries = docTree.Entries
+	}
+	if attTree, err := t.tree.Tree(path.Join(t.dir, "att")); err == nil {
+		attEntries = attTree.Entries
+	}
+
+	// Apply all changes to the attachment directory first
+	att := make(map[string]object.TreeEntry)
+	for _, ent := range attEntries {
+		if !t.deleted["att/"+ent.Name] {
+			att[ent.Name] = ent
+		}
+	}
+	root := make(map[string]object.TreeEntry)
+	for _, ent := range rootEntries {
+		if ent.Name != "att" {
+			root[ent.Name] = ent
+		}
+	}
+	for name, h := range t.staged {
+		ent := object.TreeEntry{Name: path.Base(name), Mode: filemode.Regular, Hash: h}
+		if path.Dir(name) == "att" {
+			att[ent.Name] = ent
+		} else {
+			root[name] = ent
+		}
+	}
+
+	if len(att) > 0 {
+		attHash, err := t.repo.writeTree(treeEntries(att))
+		if err != nil {
+			return gitpl.ZeroHash, err
+		}
+		root["att"] = object.TreeEntry{Name: "att", Mode: filemode.Dir, Hash: attHash}
+	}
+
+	return t.repo.writeTree(treeEntries(root))
+}
+
+func treeEntries(m map[string]object.TreeEntry) []object.TreeEntry {
+	rv := make([]object.TreeEntry, 0, len(m))
+	for _, ent := range m {
+		rv = append(rv, ent)
+	}
+	return rv
+}
+
 func (t *transaction) Rollback() error {
-	t.clone = nil
+	// Any blobs written during this transaction are left for the garbage collector
+	if !t.closed && t.ref == nil {
+		t.repo.discardBranch(t.dir)
+	}
+	t.closed = true
+	t.staged = nil
+	t.tree = nil
 	return nil
 }
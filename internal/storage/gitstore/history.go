@@ -0,0 +1,150 @@
+package gitstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	gitpl "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// DocumentRevisions lists all commits on a document's branch, most recent first
+func (g *repo) DocumentRevisions(ctx context.Context, id string) ([]storage.Revision, error) {
+	ref, err := g.repository.Reference(gitpl.NewBranchReferenceName("g"+id), false)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+
+	rv := []storage.Revision{}
+	hash := ref.Hash()
+	for ctx.Err() == nil {
+		cmt, err := g.repository.CommitObject(hash)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting commit obj")
+		}
+
+		tree, err := cmt.Tree()
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting tree obj")
+		}
+		if _, err := tree.Tree("g" + id); err != nil {
+			// This commit predates the document
+			break
+		}
+
+		rv = append(rv, storage.Revision{
+			ID:      cmt.Hash.String(),
+			Date:    cmt.Committer.When,
+			Message: strings.TrimSpace(cmt.Message),
+		})
+
+		if len(cmt.ParentHashes) == 0 {
+			break
+		}
+		hash = cmt.ParentHashes[0]
+	}
+
+	return rv, ctx.Err()
+}
+
+// GetDocumentRevision starts a read-only transaction for a document at a specific commit
+func (g *repo) GetDocumentRevision(id, revision string) (storage.DocTransaction, error) {
+	if !gitpl.IsHash(revision) {
+		return nil, fs.ErrNotExist
+	}
+	cmt, err := g.repository.CommitObject(gitpl.NewHash(revision))
+	if err == gitpl.ErrObjectNotFound {
+		return nil, fs.ErrNotExist
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error getting commit obj")
+	}
+	tree, err := cmt.Tree()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting tree obj")
+	}
+
+	// Any commit that contains the document's directory has a version of it
+	if _, err := tree.Tree("g" + id); err != nil {
+		return nil, fs.ErrNotExist
+	}
+
+	return &revisionTransaction{
+		tree: tree,
+		dir:  "g" + id,
+	}, nil
+}
+
+// A revisionTransaction reads a document as it was at a specific commit
+type revisionTransaction struct {
+	tree *object.Tree
+	dir  string
+}
+
+func (t *revisionTransaction) DocumentID() string {
+	return t.dir[1:]
+}
+
+func (t *revisionTransaction) readFile(filename string) (io.ReadCloser, error) {
+	f, err := t.tree.File(filename)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	return f.Reader()
+}
+
+func (t *revisionTransaction) ReadRootFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	return t.readFile(path.Join(t.dir, name))
+}
+func (t *revisionTransaction) WriteRootFile(ctx context.Context, name string) (io.WriteCloser, error) {
+	return nil, storage.ErrReadOnly
+}
+
+func (t *revisionTransaction) ListAttachments(context.Context) ([]string, error) {
+	rv := []string{}
+	attTree, err := t.tree.Tree(path.Join(t.dir, "att"))
+	if err != nil {
+		return rv, nil
+	}
+
+	for _, ent := range attTree.Entries {
+		n := ent.Name
+		if !ent.Mode.IsFile() || len(n) < 12 || n[0] != 't' || n[11] != '.' {
+			continue
+		}
+
+		var id int64
+		var ext string
+		if _, err := fmt.Sscanf(n, "t%010x.%s", &id, &ext); err != nil {
+			continue
+		}
+		rv = append(rv, n)
+	}
+
+	return rv, nil
+}
+func (t *revisionTransaction) ReadAttachment(ctx context.Context, name string) (io.ReadCloser, error) {
+	return t.readFile(path.Join(t.dir, "att", name))
+}
+func (t *revisionTransaction) NewAttachmentID(ctx context.Context, ext string) (string, error) {
+	return "", storage.ErrReadOnly
+}
+func (t *revisionTransaction) WriteAttachment(ctx context.Context, name string) (io.WriteCloser, error) {
+	return nil, storage.ErrReadOnly
+}
+func (t *revisionTransaction) DeleteAttachment(ctx context.Context, name string) error {
+	return storage.ErrReadOnly
+}
+
+func (t *revisionTransaction) Commit(ctx context.Context, logMessage string) error {
+	return storage.ErrReadOnly
+}
+func (t *revisionTransaction) Rollback() error {
+	t.tree = nil
+	return nil
+}
@@ -0,0 +1,135 @@
+package gitstore
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	gitpl "github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+)
+
+// committer is recorded as the author of all commits, so that committing
+// doesn't depend on the git configuration of whoever runs the server.
+var committer = object.Signature{
+	Name:  "Doc-hoarder",
+	Email: "doc-hoarder@localhost",
+}
+
+// writeBlob stores a blob in the repository
+func (g *repo) writeBlob(r io.Reader) (gitpl.Hash, error) {
+	f, err := spoolFile()
+	if err != nil {
+		return gitpl.ZeroHash, err
+	}
+	defer removeSpool(f)
+	if _, err := io.Copy(f, r); err != nil {
+		return gitpl.ZeroHash, err
+	}
+	return g.storeBlob(f)
+}
+
+// spoolFile creates a temporary file to hold the contents of a blob until
+// its size is known
+func spoolFile() (*os.File, error) {
+	return os.CreateTemp("", "doc-hoarder-blob-*")
+}
+
+func removeSpool(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// storeBlob stores the contents of a spooled file as a blob. In repositories
+// on disk, the blob is streamed into a loose object; other storers get an
+// in-memory object.
+func (g *repo) storeBlob(f *os.File) (gitpl.Hash, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return gitpl.ZeroHash, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return gitpl.ZeroHash, err
+	}
+
+	if fst, ok := g.repository.Storer.(*filesystem.Storage); ok {
+		w, err := dotgit.New(fst.Filesystem()).NewObject()
+		if err != nil {
+			return gitpl.ZeroHash, err
+		}
+		if err := w.WriteHeader(gitpl.BlobObject, size); err != nil {
+			w.Close()
+			return gitpl.ZeroHash, err
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			w.Close()
+			return gitpl.ZeroHash, err
+		}
+		if err := w.Close(); err != nil {
+			return gitpl.ZeroHash, err
+		}
+		return w.Hash(), nil
+	}
+
+	obj := g.repository.Storer.NewEncodedObject()
+	obj.SetType(gitpl.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return gitpl.ZeroHash, err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return gitpl.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return gitpl.ZeroHash, err
+	}
+	return g.repository.Storer.SetEncodedObject(obj)
+}
+
+// writeTree stores a tree object in the repository
+func (g *repo) writeTree(entries []object.TreeEntry) (gitpl.Hash, error) {
+	// Git sorts tree entries as if directory names have a trailing slash
+	sortName := func(ent object.TreeEntry) string {
+		if ent.Mode == filemode.Dir {
+			return ent.Name + "/"
+		}
+		return ent.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortName(entries[i]) < sortName(entries[j])
+	})
+
+	tree := &object.Tree{Entries: entries}
+	obj := g.repository.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return gitpl.ZeroHash, err
+	}
+	return g.repository.Storer.SetEncodedObject(obj)
+}
+
+// writeCommit stores a commit object in the repository
+func (g *repo) writeCommit(tree, parent gitpl.Hash, message string) (gitpl.Hash, error) {
+	sig := committer
+	sig.When = time.Now()
+
+	cmt := &object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   message,
+		TreeHash:  tree,
+	}
+	if !parent.IsZero() {
+		cmt.ParentHashes = []gitpl.Hash{parent}
+	}
+
+	obj := g.repository.Storer.NewEncodedObject()
+	if err := cmt.Encode(obj); err != nil {
+		return gitpl.ZeroHash, err
+	}
+	return g.repository.Storer.SetEncodedObject(obj)
+}
@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+func init() {
+	f := func(fileName string, store DocStore) (DocumentCache, error) {
+		if fileName == "" {
+			return nil, errors.New("index cache needs a file name")
+		}
+
+		rv := &indexCache{
+			store:    store,
+			Filename: fileName,
+		}
+
+		ctx := context.Background()
+		err := rv.load(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if cn, ok := store.(ChangeNotifier); ok {
+			cn.OnChange(rv.documentChanged)
+		} else {
+			log.Printf("warning: document store %T does not report changes; the document index will go stale", store)
+		}
+
+		return rv, nil
+	}
+	RegisterCacheMethod("index", f)
+	RegisterCacheMethod("file", f)
+}
+
+// indexCache keeps the metadata for all documents in memory, and saves it to
+// disk so it doesn't need to be rebuilt on every startup.
+type indexCache struct {
+	store    DocStore
+	Filename string
+
+	mu       sync.RWMutex
+	metas    map[string]DocumentMeta
+	versions map[string]string
+	ids      []string
+	byURL    map[string][]string
+
+	// saveMu makes sure only one copy of the index is written at a time, and
+	// protects saveTimer
+	saveMu    sync.Mutex
+	saveTimer *time.Timer
+}
+
+type indexFile struct {
+	Documents map[string]DocumentMeta
+
+	// Versions contains the version of each document that was read
+	Versions map[string]string `json:",omitempty"`
+}
+
+// indexSaveDelay is the time changes are collected before the index file is
+// written again. Changes that are lost in a crash are picked up when the
+// index is reconciled.
+const indexSaveDelay = 30 * time.Second
+
+func (c *indexCache) load(ctx context.Context) error {
+	f, err := os.Open(c.Filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c.Rebuild(ctx)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var contents indexFile
+	dec := json.NewDecoder(f)
+	err = dec.Decode(&contents)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.metas = contents.Documents
+	if c.metas == nil {
+		c.metas = make(map[string]DocumentMeta)
+	}
+	c.versions = contents.Versions
+	if c.versions == nil {
+		c.versions = make(map[string]string)
+	}
+	c.reindex()
+	c.mu.Unlock()
+
+	return c.Reconcile(ctx)
+}
+
+// Reconcile brings the index up to date with documents that were added to,
+// modified in or removed from the store by another process, e.g. one of the
+// command line subcommands. Only the metadata of documents whose version
+// changed is read.
+func (c *indexCache) Reconcile(ctx context.Context) error {
+	// Documents that are added while listing the store are reported through
+	// documentChanged, so only documents known beforehand can be removed
+	c.mu.RLock()
+	known := make(map[string]string, len(c.metas))
+	for docid := range c.metas {
+		known[docid] = c.versions[docid]
+	}
+	c.mu.RUnlock()
+
+	docids, err := c.store.DocumentIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool, len(docids))
+	changed := make(map[string]DocumentMeta)
+	versions := make(map[string]string)
+	for _, docid := range docids {
+		listed[docid] = true
+		if version, ok := known[docid]; ok && version == DocumentVersion(ctx, c.store, docid) {
+			continue
+		}
+		if meta, version, err := c.readMeta(ctx, docid); err == nil {
+			changed[docid] = meta
+			versions[docid] = version
+		}
+	}
+	removed := []string{}
+	for docid := range known {
+		if !listed[docid] {
+			removed = append(removed, docid)
+		}
+	}
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	for docid, meta := range changed {
+		// Keep changes that were reported while the store was being read
+		if _, ok := c.metas[docid]; ok {
+			if v, ok := known[docid]; !ok || v != c.versions[docid] {
+				continue
+			}
+		}
+		c.metas[docid] = meta
+		c.versions[docid] = versions[docid]
+	}
+	for _, docid := range removed {
+		delete(c.metas, docid)
+		delete(c.versions, docid)
+	}
+	c.reindex()
+	c.mu.Unlock()
+
+	return c.save()
+}
+
+// Rebuild discards the entire index, and reads the metadata for all documents from the store
+func (c *indexCache) Rebuild(ctx context.Context) error {
+	docids, err := c.store.DocumentIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	metas := make(map[string]DocumentMeta, len(docids))
+	versions := make(map[string]string, len(docids))
+	for _, docid := range docids {
+		meta, version, err := c.readMeta(ctx, docid)
+		if err != nil {
+			continue
+		}
+		metas[docid] = meta
+		versions[docid] = version
+	}
+
+	c.mu.Lock()
+	c.metas = metas
+	c.versions = versions
+	c.reindex()
+	c.mu.Unlock()
+
+	return c.save()
+}
+
+// readMeta reads the metadata of a document, along with the version it was read from
+func (c *indexCache) readMeta(ctx context.Context, docid string) (DocumentMeta, string, error) {
+	// Get the version first, so that changes made while the document is read
+	// are picked up later
+	version := DocumentVersion(ctx, c.store, docid)
+
+	trns, err := c.store.GetDocument(docid)
+	if err != nil {
+		return DocumentMeta{}, "", err
+	}
+	defer trns.Rollback()
+	meta, err := ReadMeta(ctx, trns)
+	return meta, version, err
+}
+
+// reindex rebuilds all lookup tables from the metadata map. The caller should hold the write lock.
+func (c *indexCache) reindex() {
+	c.ids = make([]string, 0, len(c.metas))
+	c.byURL = make(map[string][]string)
+	for docid := range c.metas {
+		c.ids = append(c.ids, docid)
+	}
+	sort.Strings(c.ids)
+
+	for _, docid := range c.ids {
+		meta := c.metas[docid]
+		if meta.URL != "" {
+			c.byURL[meta.URL] = append(c.byURL[meta.URL], docid)
+		}
+	}
+}
+
+// save writes the index to disk
+func (c *indexCache) save() error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	if c.saveTimer != nil {
+		c.saveTimer.Stop()
+		c.saveTimer = nil
+	}
+
+	// Only hold the lock while copying, so that readers and changes aren't
+	// held up by writing the file
+	c.mu.RLock()
+	contents := indexFile{
+		Documents: make(map[string]DocumentMeta, len(c.metas)),
+		Versions:  make(map[string]string, len(c.versions)),
+	}
+	for docid, meta := range c.metas {
+		contents.Documents[docid] = meta
+	}
+	for docid, version := range c.versions {
+		contents.Versions[docid] = version
+	}
+	c.mu.RUnlock()
+
+	tmpName := path.Join(path.Dir(c.Filename), "."+path.Base(c.Filename)+".tmp")
+	g, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(g)
+	err = enc.Encode(contents)
+	if err != nil {
+		g.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	err = g.Close()
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, c.Filename)
+}
+
+// saveLater writes the index to disk after a while, so that a series of
+// changes results in a single write
+func (c *indexCache) saveLater() {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	if c.saveTimer != nil {
+		return
+	}
+	c.saveTimer = time.AfterFunc(indexSaveDelay, func() {
+		if err := c.save(); err != nil {
+			log.Printf("error saving document index: %v", err)
+		}
+	})
+}
+
+func (c *indexCache) documentChanged(ctx context.Context, docid string) {
+	meta, version, err := c.readMeta(ctx, docid)
+
+	c.mu.Lock()
+	if err != nil {
+		delete(c.metas, docid)
+		delete(c.versions, docid)
+	} else {
+		c.metas[docid] = meta
+		c.versions[docid] = version
+	}
+	c.reindex()
+	c.mu.Unlock()
+
+	c.saveLater()
+}
+
+func (c *indexCache) GetDocuments(ctx context.Context, user Principal, filter Filter, limit Limit) ([]string, []DocumentMeta, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rvids := make([]string, 0, limit.Limit)
+	rv := make([]DocumentMeta, 0, limit.Limit)
+	found := 0
+	for _, docid := range c.ids {
+		meta := c.metas[docid]
+		if meta.Trashed() || !meta.CanRead(user) || !filter.Matches(meta) {
+			continue
+		}
+
+		found++
+		if found <= limit.Offset {
+			continue
+		}
+
+		rvids = append(rvids, docid)
+		rv = append(rv, meta)
+		if limit.Limit > 0 && len(rv) >= limit.Limit {
+			break
+		}
+	}
+	return rvids, rv, nil
+}
+
+func (c *indexCache) GetDocumentByURL(ctx context.Context, user_id, page_url string) (DocTransaction, bool, error) {
+	c.mu.RLock()
+	docid := ""
+	for _, id := range c.byURL[page_url] {
+		if c.metas[id].Permissions.Owner == user_id && !c.metas[id].Trashed() {
+			docid = id
+			break
+		}
+	}
+	c.mu.RUnlock()
+
+	if docid == "" {
+		return nil, false, nil
+	}
+
+	trns, err := c.store.GetDocument(docid)
+	if err != nil {
+		return nil, false, err
+	}
+	return trns, true, nil
+}
+
+func (c *indexCache) GetDocumentsByURL(ctx context.Context, user Principal, page_url string) ([]string, []DocumentMeta, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rvids := []string{}
+	rv := []DocumentMeta{}
+	for _, docid := range c.byURL[page_url] {
+		meta := c.metas[docid]
+		if !meta.Trashed() && meta.CanRead(user) {
+			rvids = append(rvids, docid)
+			rv = append(rv, meta)
+		}
+	}
+	return rvids, rv, nil
+}
+
+func (c *indexCache) GetTrash(ctx context.Context, user_id string) ([]string, []DocumentMeta, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rvids := []string{}
+	rv := []DocumentMeta{}
+	for _, docid := range c.ids {
+		meta := c.metas[docid]
+		if meta.Trashed() && meta.Permissions.Owner == user_id {
+			rvids = append(rvids, docid)
+			rv = append(rv, meta)
+		}
+	}
+	return rvids, rv, nil
+}
+
+func (c *indexCache) GetDocumentMeta(ctx context.Context, doc_id string) (DocumentMeta, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	meta, ok := c.metas[doc_id]
+	if !ok {
+		return DocumentMeta{}, fs.ErrNotExist
+	}
+	return meta, nil
+}
+
+func (c *indexCache) GetTags(ctx context.Context, user Principal) ([]TagCount, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	metas := []DocumentMeta{}
+	for _, meta := range c.metas {
+		if !meta.Trashed() && meta.CanRead(user) {
+			metas = append(metas, meta)
+		}
+	}
+	return CountTags(metas), nil
+}
+
+func (c *indexCache) GetLatestCaptures(ctx context.Context, user Principal, filter Filter, limit Limit) ([]string, []DocumentMeta, []int, error) {
+	ids, metas, err := c.GetDocuments(ctx, user, Filter{}, Limit{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ids, metas, counts := LatestCaptures(ids, metas, filter, limit)
+	return ids, metas, counts, nil
+}
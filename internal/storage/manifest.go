@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"sort"
+	"time"
+)
+
+// An AttachmentSource tells how an attachment got into a document
+type AttachmentSource string
+
+const (
+	// SourceProxied attachments were fetched by the server
+	SourceProxied AttachmentSource = "proxied"
+	// SourceUploaded attachments were sent by the client
+	SourceUploaded AttachmentSource = "uploaded"
+	// SourceImported attachments were taken from an archive file
+	SourceImported AttachmentSource = "imported"
+)
+
+// A Manifest records where the attachments of a document came from
+type Manifest struct {
+	xml.Name    `xml:"Manifest"`
+	Attachments []AttachmentOrigin `xml:"Attachment"`
+}
+
+// An AttachmentOrigin describes where an attachment came from, and what was
+// stored
+type AttachmentOrigin struct {
+	Name   string           `xml:"name,attr"`
+	Source AttachmentSource `xml:"source,attr"`
+
+	// URL is the address the attachment was originally referenced by, and
+	// FinalURL the address it was eventually fetched from, if it was
+	// redirected
+	URL      string `xml:",omitempty"`
+	FinalURL string `xml:",omitempty"`
+
+	ContentType string `xml:",omitempty"`
+	Size        int64
+	SHA256      string `xml:",omitempty"`
+
+	// Fetched is the time the attachment was retrieved, if it is known
+	Fetched *time.Time `xml:",omitempty"`
+
+	// Fetch is the outcome of the request, if the server fetched the attachment
+	Fetch *FetchResult `xml:",omitempty"`
+}
+
+// A FetchResult records how the server retrieved an attachment
+type FetchResult struct {
+	Status     int    `xml:"status,attr"`
+	RemoteAddr string `xml:",omitempty"`
+
+	// DeclaredType is the Content-Type header sent by the remote server
+	DeclaredType string `xml:",omitempty"`
+
+	// Size is the number of bytes received, which may differ from what was
+	// stored if the attachment was rewritten
+	Size     int64
+	Duration time.Duration
+	Error    string `xml:",omitempty"`
+}
+
+// ReadManifest reads the manifest of a document. Documents that predate
+// manifests get an empty one.
+func ReadManifest(ctx context.Context, trns DocTransaction) (Manifest, error) {
+	var rv Manifest
+	r, err := trns.ReadRootFile(ctx, "manifest.xml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return rv, nil
+	} else if err != nil {
+		return rv, err
+	}
+	defer r.Close()
+	dec := xml.NewDecoder(r)
+	err = dec.Decode(&rv)
+	return rv, err
+}
+
+func WriteManifest(ctx context.Context, trns DocTransaction, m Manifest) error {
+	w, err := trns.WriteRootFile(ctx, "manifest.xml")
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	err = enc.Encode(m)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Attachment returns the origin of an attachment
+func (m Manifest) Attachment(name string) (AttachmentOrigin, bool) {
+	for _, o := range m.Attachments {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return AttachmentOrigin{}, false
+}
+
+// An AttachmentRecorder keeps track of the origins of attachments itself,
+// rather than having them written to the manifest right away
+type AttachmentRecorder interface {
+	RecordAttachments(context.Context, ...AttachmentOrigin) error
+}
+
+// RecordAttachments adds or updates the origins of attachments in a
+// document's manifest. The size and hash sum of each attachment are taken
+// from its current contents. The URLs and fetch time of an attachment that
+// is already in the manifest are kept if they are left empty.
+func RecordAttachments(ctx context.Context, trns DocTransaction, origins ...AttachmentOrigin) error {
+	if len(origins) == 0 {
+		return nil
+	}
+	if ar, ok := trns.(AttachmentRecorder); ok {
+		return ar.RecordAttachments(ctx, origins...)
+	}
+	m, err := ReadManifest(ctx, trns)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range origins {
+		if o.ContentType == "" {
+			o.ContentType, _, _ = mime.ParseMediaType(mime.TypeByExtension(path.Ext(o.Name)))
+		}
+		o.Size, o.SHA256 = 0, ""
+		f, err := trns.ReadAttachment(ctx, o.Name)
+		if err == nil {
+			h := sha256.New()
+			o.Size, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			o.SHA256 = hex.EncodeToString(h.Sum(nil))
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		i := sort.Search(len(m.Attachments), func(i int) bool {
+			return m.Attachments[i].Name >= o.Name
+		})
+		if i < len(m.Attachments) && m.Attachments[i].Name == o.Name {
+			old := m.Attachments[i]
+			if o.URL == "" {
+				o.URL, o.FinalURL = old.URL, old.FinalURL
+			}
+			if o.Fetched == nil {
+				o.Fetched, o.Fetch = old.Fetched, old.Fetch
+			}
+			m.Attachments[i] = o
+		} else {
+			m.Attachments = append(m.Attachments, AttachmentOrigin{})
+			copy(m.Attachments[i+1:], m.Attachments[i:])
+			m.Attachments[i] = o
+		}
+	}
+
+	return WriteManifest(ctx, trns, m)
+}
+
+// A ManifestBuffer collects the origins of the attachments of a transaction,
+// and writes them to the manifest only once, when the transaction is
+// committed. This keeps a transaction that records one attachment at a time
+// from writing a new copy of the manifest for each of them.
+type ManifestBuffer struct {
+	DocTransaction
+
+	// Pending contains the origins that have not been written yet
+	Pending []AttachmentOrigin
+}
+
+func (t *ManifestBuffer) RecordAttachments(ctx context.Context, origins ...AttachmentOrigin) error {
+	t.Pending = append(t.Pending, origins...)
+	return nil
+}
+
+func (t *ManifestBuffer) AttachmentNameFromID(ctx context.Context, attID string) (string, error) {
+	return AttachmentNameFromID(ctx, t.DocTransaction, attID)
+}
+
+// Commit writes the pending origins to the manifest, and commits the transaction
+func (t *ManifestBuffer) Commit(ctx context.Context, logMessage string) error {
+	err := RecordAttachments(ctx, t.DocTransaction, t.Pending...)
+	if err != nil {
+		return err
+	}
+	t.Pending = nil
+	return t.DocTransaction.Commit(ctx, logMessage)
+}
@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"encoding/xml"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -16,14 +18,26 @@ type DocumentMeta struct {
 	Date        time.Time      `xml:",omitEmpty"`
 	Status      DocumentStatus `xml:",omitEmpty"`
 	CaptureDate time.Time      `xml:",omitEmpty"`
-	Permissions struct {
-		Owner       string
-		Public      bool
-		ReadUsers   []string `xml:"ReadUsers>User,omitEmpty"`
-		ReadGroups  []string `xml:"ReadGroups>Group",omitEmpty`
-		WriteUsers  []string `xml:"WriteUsers>User",omitEmpty`
-		WriteGroups []string `xml:"WriteGroups>Group",omitEmpty`
-	}
+	Tags        []string       `xml:"Tags>Tag,omitempty"`
+
+	// TrashDate is the moment a document was moved to the trash. Once the
+	// retention period has passed, it gets deleted permanently.
+	TrashDate time.Time `xml:",omitempty"`
+	// TrashedStatus is the status the document had before it was trashed
+	TrashedStatus DocumentStatus `xml:",omitempty"`
+
+	Permissions Permissions
+}
+
+// Permissions determine who can see and modify a document or collection. The
+// owner can always do both.
+type Permissions struct {
+	Owner       string
+	Public      bool
+	ReadUsers   []string `xml:"ReadUsers>User,omitempty"`
+	ReadGroups  []string `xml:"ReadGroups>Group,omitempty"`
+	WriteUsers  []string `xml:"WriteUsers>User,omitempty"`
+	WriteGroups []string `xml:"WriteGroups>Group,omitempty"`
 }
 
 type DocumentStatus string
@@ -31,6 +45,7 @@ type DocumentStatus string
 const (
 	StatusDraft  DocumentStatus = "draft"
 	StatusStatic                = "static"
+	StatusTrash                 = "trash"
 )
 
 func ReadMeta(ctx context.Context, trns DocTransaction) (DocumentMeta, error) {
@@ -39,6 +54,7 @@ func ReadMeta(ctx context.Context, trns DocTransaction) (DocumentMeta, error) {
 	if err != nil {
 		return rv, err
 	}
+	defer r.Close()
 	dec := xml.NewDecoder(r)
 	err = dec.Decode(&rv)
 	if err != nil {
@@ -53,5 +69,157 @@ func WriteMeta(ctx context.Context, trns DocTransaction, meta DocumentMeta) erro
 	}
 	enc := xml.NewEncoder(w)
 	enc.Indent("", "\t")
-	return enc.Encode(meta)
+	err = enc.Encode(meta)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Trashed returns true if this document is in the trash
+func (meta DocumentMeta) Trashed() bool {
+	return meta.Status == StatusTrash
+}
+
+// A Principal is a user, along with the groups they are a member of. The zero
+// value represents an anonymous visitor.
+type Principal struct {
+	UserID string
+	Groups []string
+}
+
+// inList checks if any of the needles are present in a list
+func inList(list []string, needles ...string) bool {
+	for _, s := range list {
+		for _, n := range needles {
+			if s == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsOwner checks if this principal is the owner
+func (perms Permissions) IsOwner(p Principal) bool {
+	return p.UserID != "" && perms.Owner == p.UserID
+}
+
+// CanRead checks if a user is allowed to see the object these permissions belong to
+func (perms Permissions) CanRead(p Principal) bool {
+	if perms.Public {
+		return true
+	}
+	if p.UserID == "" {
+		return false
+	}
+	if inList(perms.ReadUsers, p.UserID) || inList(perms.ReadGroups, p.Groups...) {
+		return true
+	}
+	return perms.CanWrite(p)
+}
+
+// CanWrite checks if a user is allowed to modify the object these permissions belong to
+func (perms Permissions) CanWrite(p Principal) bool {
+	if perms.IsOwner(p) {
+		return true
+	}
+	if p.UserID == "" {
+		return false
+	}
+	return inList(perms.WriteUsers, p.UserID) || inList(perms.WriteGroups, p.Groups...)
+}
+
+// IsOwner checks if this principal owns the document
+func (meta DocumentMeta) IsOwner(p Principal) bool {
+	return meta.Permissions.IsOwner(p)
+}
+
+// CanRead checks if a user is allowed to see this document
+func (meta DocumentMeta) CanRead(p Principal) bool {
+	if meta.Trashed() {
+		// Only the owner can see (and restore) trashed documents
+		return meta.IsOwner(p)
+	}
+	return meta.Permissions.CanRead(p)
+}
+
+// CanWrite checks if a user is allowed to modify this document
+func (meta DocumentMeta) CanWrite(p Principal) bool {
+	if meta.Trashed() {
+		return meta.IsOwner(p)
+	}
+	return meta.Permissions.CanWrite(p)
+}
+
+// SetPermissions replaces the access control lists of a document. The owner
+// of a document cannot be changed this way.
+func SetPermissions(ctx context.Context, st DocStore, docID string, perms Permissions) error {
+	trns, err := st.GetDocument(docID)
+	if err != nil {
+		return err
+	}
+	defer trns.Rollback()
+
+	meta, err := ReadMeta(ctx, trns)
+	if err != nil {
+		return err
+	}
+
+	perms.Owner = meta.Permissions.Owner
+	meta.Permissions = perms
+	err = WriteMeta(ctx, trns, meta)
+	if err != nil {
+		return err
+	}
+	return trns.Commit(ctx, "Change permissions")
+}
+
+// NormalizeTags cleans up a list of tags: tags are trimmed and lowercased,
+// and empty and duplicate tags are removed.
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	rv := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		rv = append(rv, tag)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// HasTags checks if a document has all of the specified tags
+func (meta DocumentMeta) HasTags(tags ...string) bool {
+	for _, tag := range tags {
+		if !inList(meta.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetTags replaces the tags of a document
+func SetTags(ctx context.Context, st DocStore, docID string, tags []string) error {
+	trns, err := st.GetDocument(docID)
+	if err != nil {
+		return err
+	}
+	defer trns.Rollback()
+
+	meta, err := ReadMeta(ctx, trns)
+	if err != nil {
+		return err
+	}
+
+	meta.Tags = NormalizeTags(tags)
+	err = WriteMeta(ctx, trns, meta)
+	if err != nil {
+		return err
+	}
+	return trns.Commit(ctx, "Change tags")
 }
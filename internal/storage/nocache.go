@@ -13,8 +13,8 @@ type noCache struct {
 	store DocStore
 }
 
-func (c noCache) GetDocuments(ctx context.Context, user_id string, limit Limit) ([]string, []DocumentMeta, error) {
-	docids, err := c.store.DocumentIDs(ctx) // TODO: filter by read permissions
+func (c noCache) GetDocuments(ctx context.Context, user Principal, filter Filter, limit Limit) ([]string, []DocumentMeta, error) {
+	docids, err := c.store.DocumentIDs(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -29,17 +29,7 @@ func (c noCache) GetDocuments(ctx context.Context, user_id string, limit Limit)
 		meta, _ := ReadMeta(ctx, trns)
 		trns.Rollback()
 
-		canView := false
-		if meta.Permissions.Public {
-			canView = true
-		}
-		if user_id != "" {
-			if meta.Permissions.Owner == user_id {
-				canView = true
-			}
-			// TODO: apply ReadUsers/ReadGroups
-		}
-		if !canView {
+		if meta.Trashed() || !meta.CanRead(user) || !filter.Matches(meta) {
 			continue
 		}
 
@@ -71,7 +61,7 @@ func (c noCache) GetDocumentByURL(ctx context.Context, user_id, page_url string)
 			return nil, false, err
 		}
 
-		if meta.URL == page_url && meta.Permissions.Owner == user_id {
+		if meta.URL == page_url && meta.Permissions.Owner == user_id && !meta.Trashed() {
 			return trns, true, nil
 		}
 
@@ -79,6 +69,50 @@ func (c noCache) GetDocumentByURL(ctx context.Context, user_id, page_url string)
 	}
 	return nil, false, nil
 }
+func (c noCache) GetDocumentsByURL(ctx context.Context, user Principal, page_url string) ([]string, []DocumentMeta, error) {
+	docids, err := c.store.DocumentIDs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	rvids := []string{}
+	rv := []DocumentMeta{}
+	for _, docid := range docids {
+		trns, err := c.store.GetDocument(docid)
+		if err != nil {
+			return nil, nil, err
+		}
+		meta, _ := ReadMeta(ctx, trns)
+		trns.Rollback()
+
+		if meta.URL == page_url && !meta.Trashed() && meta.CanRead(user) {
+			rvids = append(rvids, docid)
+			rv = append(rv, meta)
+		}
+	}
+	return rvids, rv, nil
+}
+func (c noCache) GetTrash(ctx context.Context, user_id string) ([]string, []DocumentMeta, error) {
+	docids, err := c.store.DocumentIDs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	rvids := []string{}
+	rv := []DocumentMeta{}
+	for _, docid := range docids {
+		trns, err := c.store.GetDocument(docid)
+		if err != nil {
+			return nil, nil, err
+		}
+		meta, _ := ReadMeta(ctx, trns)
+		trns.Rollback()
+
+		if meta.Trashed() && meta.Permissions.Owner == user_id {
+			rvids = append(rvids, docid)
+			rv = append(rv, meta)
+		}
+	}
+	return rvids, rv, nil
+}
 func (c noCache) GetDocumentMeta(ctx context.Context, doc_id string) (DocumentMeta, error) {
 	trns, err := c.store.GetDocument(doc_id)
 	if err != nil {
@@ -87,3 +121,18 @@ func (c noCache) GetDocumentMeta(ctx context.Context, doc_id string) (DocumentMe
 	defer trns.Rollback()
 	return ReadMeta(ctx, trns)
 }
+func (c noCache) GetTags(ctx context.Context, user Principal) ([]TagCount, error) {
+	_, metas, err := c.GetDocuments(ctx, user, Filter{}, Limit{})
+	if err != nil {
+		return nil, err
+	}
+	return CountTags(metas), nil
+}
+func (c noCache) GetLatestCaptures(ctx context.Context, user Principal, filter Filter, limit Limit) ([]string, []DocumentMeta, []int, error) {
+	ids, metas, err := c.GetDocuments(ctx, user, Filter{}, Limit{})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ids, metas, counts := LatestCaptures(ids, metas, filter, limit)
+	return ids, metas, counts, nil
+}
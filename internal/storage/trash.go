@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// ErrNotTrashed is returned when restoring a document that isn't in the trash
+var ErrNotTrashed = errors.New("this document is not in the trash")
+
+// TrashDocument moves a document to the trash
+func TrashDocument(ctx context.Context, st DocStore, docID string) error {
+	trns, err := st.GetDocument(docID)
+	if err != nil {
+		return err
+	}
+	defer trns.Rollback()
+
+	meta, err := ReadMeta(ctx, trns)
+	if err != nil {
+		return err
+	}
+	if meta.Trashed() {
+		return nil
+	}
+
+	meta.TrashedStatus = meta.Status
+	meta.Status = StatusTrash
+	meta.TrashDate = time.Now()
+	err = WriteMeta(ctx, trns, meta)
+	if err != nil {
+		return err
+	}
+	return trns.Commit(ctx, "Move to trash")
+}
+
+// RestoreDocument takes a document out of the trash
+func RestoreDocument(ctx context.Context, st DocStore, docID string) error {
+	trns, err := st.GetDocument(docID)
+	if err != nil {
+		return err
+	}
+	defer trns.Rollback()
+
+	meta, err := ReadMeta(ctx, trns)
+	if err != nil {
+		return err
+	}
+	if !meta.Trashed() {
+		return ErrNotTrashed
+	}
+
+	meta.Status = meta.TrashedStatus
+	if meta.Status == "" {
+		meta.Status = StatusStatic
+	}
+	meta.TrashedStatus = ""
+	meta.TrashDate = time.Time{}
+	err = WriteMeta(ctx, trns, meta)
+	if err != nil {
+		return err
+	}
+	return trns.Commit(ctx, "Restore from trash")
+}
+
+// EmptyTrash permanently deletes all trashed documents that were trashed
+// before a certain moment. If owner is not empty, only documents owned by that
+// user are deleted. It returns the IDs of all deleted documents.
+func EmptyTrash(ctx context.Context, st DocStore, owner string, before time.Time) ([]string, error) {
+	ids, err := st.DocumentIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []string
+	for _, docID := range ids {
+		if err := ctx.Err(); err != nil {
+			return rv, err
+		}
+
+		trns, err := st.GetDocument(docID)
+		if err != nil {
+			return rv, err
+		}
+		meta, err := ReadMeta(ctx, trns)
+		trns.Rollback()
+		if err != nil {
+			continue
+		}
+
+		if !meta.Trashed() || !meta.TrashDate.Before(before) {
+			continue
+		}
+		if owner != "" && meta.Permissions.Owner != owner {
+			continue
+		}
+
+		err = st.DeleteDocument(ctx, docID)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return rv, err
+		}
+		rv = append(rv, docID)
+	}
+	return rv, nil
+}
@@ -0,0 +1,265 @@
+// Package upload receives files in chunks. Chunks are appended to a partial
+// file on disk, so that an interrupted upload can be resumed from where it
+// left off. Only once a file is complete and its checksum matches, is it
+// copied into a document transaction.
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrChecksum is returned if the contents of a chunk or a file do not match their checksum
+var ErrChecksum = errors.New("checksum mismatch")
+
+// An OffsetError is returned if a chunk does not continue where the previous
+// one left off, or if a file is completed before all of it was received
+type OffsetError struct {
+	Offset   int64
+	Received int64
+}
+
+func (e OffsetError) Error() string {
+	return fmt.Sprintf("expected offset %d; received %d bytes so far", e.Offset, e.Received)
+}
+
+// A Dir holds the partial files of all uploads in progress
+type Dir struct {
+	Path string
+
+	// mu protects the files map. Directories are only removed while it is held.
+	mu sync.Mutex
+
+	// files contains a lock for each partial file that is in use, so that
+	// only one chunk at a time is written to it
+	files map[string]*fileLock
+}
+
+type fileLock struct {
+	mu    sync.Mutex
+	users int
+}
+
+// lock acquires the lock for a partial file, and returns a function that
+// releases it
+func (d *Dir) lock(fileName string) func() {
+	d.mu.Lock()
+	if d.files == nil {
+		d.files = make(map[string]*fileLock)
+	}
+	l, ok := d.files[fileName]
+	if !ok {
+		l = &fileLock{}
+		d.files[fileName] = l
+	}
+	l.users++
+	d.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		d.mu.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(d.files, fileName)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// inUse checks if a partial file, or any file in a directory, is locked. The
+// caller must hold d.mu.
+func (d *Dir) inUse(fileName string) bool {
+	for f := range d.files {
+		if f == fileName || strings.HasPrefix(f, fileName+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open creates a directory for partial uploads
+func Open(path string) (*Dir, error) {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Dir{Path: path}, nil
+}
+
+// fileName returns the location of the partial file for a file in a document
+func (d *Dir) fileName(docID, name string) (string, error) {
+	if docID == "" || strings.ContainsAny(docID, "/\\.") || name == "" || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid upload name '%s/%s'", docID, name)
+	}
+	return filepath.Join(d.Path, docID, strings.ReplaceAll(name, "/", "_")), nil
+}
+
+// Received returns the number of bytes received so far for a file
+func (d *Dir) Received(docID, name string) (int64, error) {
+	fileName, err := d.fileName(docID, name)
+	if err != nil {
+		return 0, err
+	}
+	defer d.lock(fileName)()
+
+	fi, err := os.Stat(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Append writes a chunk at an offset in the partial file, and returns the
+// number of bytes received so far. The offset can be at most the number of
+// bytes received; anything after the offset is discarded, so a chunk can be
+// sent again if its response got lost. If chunkSum is not empty, it is
+// checked against the hex-encoded SHA-256 hash of the chunk.
+func (d *Dir) Append(docID, name string, offset int64, chunk io.Reader, chunkSum string) (int64, error) {
+	fileName, err := d.fileName(docID, name)
+	if err != nil {
+		return 0, err
+	}
+	defer d.lock(fileName)()
+
+	err = os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 || offset > fi.Size() {
+		return fi.Size(), OffsetError{Offset: offset, Received: fi.Size()}
+	}
+	if offset < fi.Size() {
+		if err := f.Truncate(offset); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), chunk)
+	if err == nil && chunkSum != "" && !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), chunkSum) {
+		err = ErrChecksum
+	}
+	if err != nil {
+		// Discard the partial chunk, so the client can send it again
+		f.Truncate(offset)
+		return offset, err
+	}
+	return offset + n, nil
+}
+
+// Complete checks that all size bytes of a file were received and that they
+// match the hex-encoded SHA-256 hash sum, and then copies the file to w. The
+// partial file is removed afterwards. An empty sum is not checked.
+func (d *Dir) Complete(docID, name string, size int64, sum string, w io.Writer) error {
+	fileName, err := d.fileName(docID, name)
+	if err != nil {
+		return err
+	}
+	defer d.lock(fileName)()
+
+	f, err := os.Open(fileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return OffsetError{Offset: size, Received: 0}
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() != size {
+		return OffsetError{Offset: size, Received: fi.Size()}
+	}
+
+	if sum != "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), sum) {
+			return ErrChecksum
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.Copy(w, f); err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(fileName)
+}
+
+// Discard removes all partial files for a document
+func (d *Dir) Discard(docID string) error {
+	if docID == "" || strings.ContainsAny(docID, "/\\.") {
+		return fmt.Errorf("invalid document ID '%s'", docID)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return os.RemoveAll(filepath.Join(d.Path, docID))
+}
+
+// Prune removes all partial files that have not been written to since a
+// certain time
+func (d *Dir) Prune(before time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	docs, err := os.ReadDir(d.Path)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if !doc.IsDir() {
+			continue
+		}
+		dir := filepath.Join(d.Path, doc.Name())
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		left := len(files)
+		for _, file := range files {
+			fi, err := file.Info()
+			if err != nil || fi.ModTime().After(before) || d.inUse(filepath.Join(dir, file.Name())) {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, file.Name())); err == nil {
+				left--
+			}
+		}
+		if left == 0 && !d.inUse(dir) {
+			os.Remove(dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestChunkedUpload(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const docID, name = "0123456789", "att/t0123456789.css"
+	chunks := []string{"body { ", "color: red; ", "}"}
+	whole := strings.Join(chunks, "")
+
+	var offset int64
+	for _, c := range chunks {
+		offset, err = d.Append(docID, name, offset, strings.NewReader(c), sum(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if offset != int64(len(whole)) {
+		t.Errorf("received %d bytes; expected %d", offset, len(whole))
+	}
+
+	// Sending the last chunk again is fine
+	offset, err = d.Append(docID, name, offset-1, strings.NewReader("}"), sum("}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Skipping ahead is not
+	var oe OffsetError
+	_, err = d.Append(docID, name, offset+10, strings.NewReader("x"), "")
+	if !errors.As(err, &oe) || oe.Received != offset {
+		t.Errorf("expected an offset error; got %v", err)
+	}
+
+	// Neither is a corrupted chunk, which is discarded
+	_, err = d.Append(docID, name, offset, strings.NewReader("garbage"), sum("something else"))
+	if err != ErrChecksum {
+		t.Errorf("expected a checksum error; got %v", err)
+	}
+	if n, err := d.Received(docID, name); err != nil || n != offset {
+		t.Errorf("after a bad chunk, %d bytes should have been received; got %d (%v)", offset, n, err)
+	}
+
+	var b bytes.Buffer
+	if err := d.Complete(docID, name, offset, sum("something else"), &b); err != ErrChecksum {
+		t.Errorf("expected a checksum error; got %v", err)
+	}
+	if err := d.Complete(docID, name, offset+1, sum(whole), &b); !errors.As(err, &oe) {
+		t.Errorf("expected an offset error; got %v", err)
+	}
+	if err := d.Complete(docID, name, offset, sum(whole), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != whole {
+		t.Errorf("uploaded file has contents '%s'", b.String())
+	}
+	if n, _ := d.Received(docID, name); n != 0 {
+		t.Errorf("partial file should be removed after completing the upload")
+	}
+
+	if _, err := d.Append("../etc", "passwd", 0, strings.NewReader("x"), ""); err == nil {
+		t.Errorf("invalid document IDs should be rejected")
+	}
+}
+
+// blockingWriter waits until it is released before accepting any writes
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	close(w.started)
+	<-w.release
+	return len(p), nil
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	d, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const docID = "0123456789"
+	if _, err := d.Append(docID, "document.html", 0, strings.NewReader("<html>"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Completing a file that is slow to copy should not hold up other uploads
+	w := blockingWriter{make(chan struct{}), make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		done <- d.Complete(docID, "document.html", 6, sum("<html>"), w)
+	}()
+	<-w.started
+
+	appended := make(chan error, 1)
+	go func() {
+		_, err := d.Append(docID, "att/t0123456789.css", 0, strings.NewReader("body {}"), "")
+		appended <- err
+	}()
+	select {
+	case err := <-appended:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("appending to one file waited for another file to be completed")
+	}
+
+	close(w.release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,117 @@
+package warc
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// ExportStore writes all documents in a store to a WARC file
+func ExportStore(ctx context.Context, w *Writer, st storage.DocStore) error {
+	ids, err := st.DocumentIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := ExportDocument(ctx, w, st, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportDocument writes a single document to a WARC file. The document itself
+// becomes a response record for its original URL, each attachment becomes a
+// resource record, and the document's metadata becomes a metadata record.
+func ExportDocument(ctx context.Context, w *Writer, st storage.DocStore, id string) error {
+	trns, err := st.GetDocument(id)
+	if err != nil {
+		return err
+	}
+	defer trns.Rollback()
+
+	meta, err := storage.ReadMeta(ctx, trns)
+	if err != nil {
+		return err
+	}
+
+	target := meta.URL
+	if target == "" {
+		target = "urn:doc-hoarder:g" + id
+	}
+	date := meta.CaptureDate
+	if date.IsZero() {
+		date = meta.Date
+	}
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = "text/html"
+	}
+
+	responseID := ""
+	f, err := trns.ReadRootFile(ctx, "document.bin")
+	if err == nil {
+		responseID, err = w.WriteResponse(target, date, contentType, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	atts, err := trns.ListAttachments(ctx)
+	if err != nil {
+		return err
+	}
+	for _, att := range atts {
+		f, err := trns.ReadAttachment(ctx, att)
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteResource(attachmentURI(target, att), date, mime.TypeByExtension(path.Ext(att)), responseID, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err = trns.ReadRootFile(ctx, "meta.xml")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = w.WriteMetadata(target, date, "application/xml", responseID, f)
+	return err
+}
+
+// attachmentURI returns the URI for an attachment record. Captured documents
+// refer to attachments using relative links (att/t0123456789.css), so the
+// attachment's URI is resolved against the document's URL in order for replay
+// tools to find them.
+func attachmentURI(target, att string) string {
+	base, err := url.Parse(target)
+	if err != nil || !base.IsAbs() || base.Opaque != "" {
+		return target + "/att/" + att
+	}
+	return base.ResolveReference(&url.URL{Path: "att/" + att}).String()
+}
+
+// DefaultWarcinfo returns the fields for a warcinfo record describing this software
+func DefaultWarcinfo(software string) Header {
+	var h Header
+	h.Add("software", software)
+	h.Add("format", "WARC File Format 1.1")
+	h.Add("conformsTo", "http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/")
+	h.Add("created", time.Now().UTC().Format(time.RFC3339))
+	return h
+}
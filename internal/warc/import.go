@@ -0,0 +1,307 @@
+package warc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/capture"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// ImportOptions control the metadata of imported documents
+type ImportOptions struct {
+	Owner  string
+	Public bool
+}
+
+// ErrNoOwner is returned when imported documents would have no owner, and would not be public either
+var ErrNoOwner = errors.New("imported documents need an owner, unless they are public")
+
+// ImportResult lists the documents that were created during an import
+type ImportResult struct {
+	DocumentIDs []string
+
+	// Missing lists all subresources that could not be found in the WARC file
+	Missing []string
+}
+
+// maxRedirects limits the number of redirects followed when looking up a subresource
+const maxRedirects int = 5
+
+// Import creates a new document for every HTML response in a WARC file.
+// Subresources found in the same file are attached to each document.
+func Import(ctx context.Context, st storage.DocStore, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	var rv ImportResult
+	if opts.Owner == "" && !opts.Public {
+		return rv, ErrNoOwner
+	}
+
+	dir, err := os.MkdirTemp("", "warc-import-*")
+	if err != nil {
+		return rv, err
+	}
+	defer os.RemoveAll(dir)
+
+	// First pass: copy all payloads to temporary files, so that subresources
+	// can be found regardless of their position in the file.
+	arch, err := readArchive(ctx, r, dir)
+	if err != nil {
+		return rv, err
+	}
+
+	for _, e := range arch.entries {
+		if !isHTML(e.ContentType) {
+			continue
+		}
+		id, res, err := arch.importPage(ctx, st, e, opts)
+		if err != nil {
+			return rv, fmt.Errorf("error importing %s: %w", e.URI, err)
+		}
+		rv.DocumentIDs = append(rv.DocumentIDs, id)
+		rv.Missing = append(rv.Missing, res.Missing...)
+	}
+
+	return rv, nil
+}
+
+type archiveEntry struct {
+	URI         string
+	Date        time.Time
+	ContentType string
+	Filename    string
+}
+
+type archive struct {
+	entries   []archiveEntry
+	byURI     map[string][]int
+	redirects map[string]string
+}
+
+func readArchive(ctx context.Context, r io.Reader, dir string) (*archive, error) {
+	rd, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	arch := &archive{
+		byURI:     make(map[string][]int),
+		redirects: make(map[string]string),
+	}
+
+	for ctx.Err() == nil {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		uri := normalizeURI(rec.TargetURI())
+		if uri == "" {
+			continue
+		}
+
+		var contentType string
+		var payload io.Reader
+
+		if rec.Type() == "response" && strings.HasPrefix(rec.Header.Get("Content-Type"), "application/http") {
+			resp, err := http.ReadResponse(bufio.NewReader(rec.Body), nil)
+			if err != nil {
+				// Skip malformed responses
+				continue
+			}
+			if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+				if loc, err := url.Parse(uri); err == nil {
+					if target, err := loc.Parse(resp.Header.Get("Location")); err == nil {
+						arch.redirects[uri] = normalizeURI(target.String())
+					}
+				}
+				resp.Body.Close()
+				continue
+			} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				resp.Body.Close()
+				continue
+			}
+
+			contentType = resp.Header.Get("Content-Type")
+			payload = resp.Body
+			if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+				gz, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					continue
+				}
+				payload = gz
+			}
+		} else if rec.Type() == "resource" {
+			contentType = rec.Header.Get("Content-Type")
+			payload = rec.Body
+		} else {
+			continue
+		}
+
+		f, err := os.CreateTemp(dir, "payload-*")
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(f, payload)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		arch.byURI[uri] = append(arch.byURI[uri], len(arch.entries))
+		arch.entries = append(arch.entries, archiveEntry{
+			URI:         uri,
+			Date:        rec.Date(),
+			ContentType: contentType,
+			Filename:    f.Name(),
+		})
+	}
+
+	return arch, ctx.Err()
+}
+
+// lookup finds the capture of a URL closest to a point in time, following redirects if necessary
+func (arch *archive) lookup(uri string, t time.Time) (archiveEntry, bool) {
+	for i := 0; i <= maxRedirects; i++ {
+		if idx, ok := arch.byURI[uri]; ok {
+			best := arch.entries[idx[0]]
+			for _, j := range idx[1:] {
+				if absDuration(t.Sub(arch.entries[j].Date)) < absDuration(t.Sub(best.Date)) {
+					best = arch.entries[j]
+				}
+			}
+			return best, true
+		}
+
+		target, ok := arch.redirects[uri]
+		if !ok {
+			break
+		}
+		uri = target
+	}
+	return archiveEntry{}, false
+}
+
+func (arch *archive) importPage(ctx context.Context, st storage.DocStore, e archiveEntry, opts ImportOptions) (string, capture.Result, error) {
+	var res capture.Result
+
+	pageURL, err := url.Parse(e.URI)
+	if err != nil {
+		return "", res, err
+	}
+
+	f, err := os.Open(e.Filename)
+	if err != nil {
+		return "", res, err
+	}
+	defer f.Close()
+
+	id, err := st.NewDocumentID(ctx)
+	if err != nil {
+		return "", res, err
+	}
+	trns, err := st.GetDocument(id)
+	if err != nil {
+		return "", res, err
+	}
+	commit := false
+	defer func() {
+		if !commit {
+			trns.Rollback()
+		}
+	}()
+
+	fetch := func(ctx context.Context, u *url.URL) (string, io.ReadCloser, error) {
+		sub, ok := arch.lookup(normalizeURI(u.String()), e.Date)
+		if !ok {
+			return "", nil, fmt.Errorf("%s not found in WARC file", u)
+		}
+		if isHTML(sub.ContentType) {
+			return "", nil, capture.ErrUnsupportedType
+		}
+		g, err := os.Open(sub.Filename)
+		if err != nil {
+			return "", nil, err
+		}
+		return sub.ContentType, g, nil
+	}
+
+	res, err = capture.Page(ctx, trns, pageURL, f, fetch)
+	if err != nil {
+		return "", res, err
+	}
+	for i := range res.Attachments {
+		res.Attachments[i].Source = storage.SourceImported
+		res.Attachments[i].Fetched = nil
+		if !e.Date.IsZero() {
+			date := e.Date
+			res.Attachments[i].Fetched = &date
+		}
+	}
+	err = storage.RecordAttachments(ctx, trns, res.Attachments...)
+	if err != nil {
+		return "", res, err
+	}
+
+	meta := storage.DocumentMeta{
+		Title:       res.Title,
+		URL:         e.URI,
+		ContentType: "text/html",
+		IconID:      res.IconID,
+		Status:      storage.StatusStatic,
+		CaptureDate: e.Date,
+	}
+	if meta.Title == "" {
+		meta.Title = path.Base(pageURL.Path)
+	}
+	meta.Permissions.Owner = opts.Owner
+	meta.Permissions.Public = opts.Public
+
+	err = storage.WriteMeta(ctx, trns, meta)
+	if err != nil {
+		return "", res, err
+	}
+
+	err = trns.Commit(ctx, "Import from WARC file")
+	if err != nil {
+		return "", res, err
+	}
+	commit = true
+	return id, res, nil
+}
+
+func normalizeURI(uri string) string {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	u.Fragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+func isHTML(contentType string) bool {
+	ct, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (ct == "text/html" || ct == "application/xhtml+xml")
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,124 @@
+package warc
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Reader reads records from a WARC file. Both WARC/1.0 and WARC/1.1 files
+// are supported, either uncompressed or gzip-compressed.
+type Reader struct {
+	br   *bufio.Reader
+	body *io.LimitedReader
+}
+
+// A Record is a single record in a WARC file. Its content block can be read
+// from Body until the next call to Next.
+type Record struct {
+	Version string
+	Header  Header
+	Body    io.Reader
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		// Gzip-compressed WARC files consist of one gzip member per record,
+		// which the gzip reader handles transparently.
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(gz)
+	}
+
+	return &Reader{br: br}, nil
+}
+
+// Next advances to the next record. It returns io.EOF if there are no more records.
+func (r *Reader) Next() (*Record, error) {
+	if r.body != nil {
+		if _, err := io.Copy(io.Discard, r.body); err != nil {
+			return nil, err
+		}
+		r.body = nil
+	}
+
+	// Skip the blank lines separating records
+	var line string
+	for {
+		l, err := r.br.ReadString('\n')
+		if err == io.EOF && l == "" {
+			return nil, io.EOF
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(l, "\r\n")
+		if line != "" {
+			break
+		} else if err == io.EOF {
+			return nil, io.EOF
+		}
+	}
+
+	if line != "WARC/1.0" && line != "WARC/1.1" {
+		return nil, fmt.Errorf("unsupported WARC version '%s'", line)
+	}
+	rec := &Record{Version: line}
+
+	for {
+		l, err := r.br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("error reading record header: %w", err)
+		}
+		l = strings.TrimRight(l, "\r\n")
+		if l == "" {
+			break
+		}
+		if (l[0] == ' ' || l[0] == '\t') && len(rec.Header) > 0 {
+			// Continuation of the previous field
+			rec.Header[len(rec.Header)-1][1] += " " + strings.TrimSpace(l)
+			continue
+		}
+		name, value, ok := strings.Cut(l, ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed header field '%s'", l)
+		}
+		rec.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	length, err := strconv.ParseInt(rec.Header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid content length '%s'", rec.Header.Get("Content-Length"))
+	}
+
+	r.body = &io.LimitedReader{R: r.br, N: length}
+	rec.Body = r.body
+	return rec, nil
+}
+
+// Type returns the record's WARC-Type
+func (rec *Record) Type() string {
+	return rec.Header.Get("WARC-Type")
+}
+
+// TargetURI returns the record's WARC-Target-URI
+func (rec *Record) TargetURI() string {
+	// WARC/1.0 has angle brackets around the URI
+	return strings.TrimSuffix(strings.TrimPrefix(rec.Header.Get("WARC-Target-URI"), "<"), ">")
+}
+
+// Date returns the record's WARC-Date
+func (rec *Record) Date() time.Time {
+	t, err := time.Parse(time.RFC3339Nano, rec.Header.Get("WARC-Date"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,125 @@
+package warc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	var b bytes.Buffer
+	w := NewWriter(&b, true)
+	date := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := w.WriteWarcinfo("test.warc.gz", DefaultWarcinfo("doc-hoarder/test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.WriteResponse("http://example.org/a/page.html", date, "text/html; charset=utf-8", strings.NewReader(`<!DOCTYPE html>
+<html><head><title>Test page</title><link rel="stylesheet" href="style.css"><script>alert(1)</script></head>
+<body><img src="/img/foo.png" onload="alert(2)"><a href="other.html">other</a></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.WriteResponse("http://example.org/a/style.css", date, "text/css", strings.NewReader(`body { background: url('../img/bg.png'); }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.WriteResource("http://example.org/img/foo.png", date, "image/png", "", strings.NewReader("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.WriteResource("http://example.org/img/bg.png", date, "image/png", "", strings.NewReader("bg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.GetDocStore("fs:" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Import(ctx, store, bytes.NewReader(b.Bytes()), ImportOptions{}); err != ErrNoOwner {
+		t.Errorf("Imports without an owner should fail; got error %v", err)
+	}
+
+	res, err := Import(ctx, store, &b, ImportOptions{Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.DocumentIDs) != 1 {
+		t.Fatalf("Expected one document; got %v", res.DocumentIDs)
+	}
+	if len(res.Missing) != 0 {
+		t.Errorf("Unexpected missing subresources: %v", res.Missing)
+	}
+
+	trns, err := store.GetDocument(res.DocumentIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trns.Rollback()
+
+	meta, err := storage.ReadMeta(ctx, trns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Test page" || meta.URL != "http://example.org/a/page.html" || !meta.CaptureDate.Equal(date) || meta.Permissions.Owner != "alice" {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+
+	atts, err := trns.ListAttachments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 3 {
+		t.Errorf("Expected three attachments; got %v", atts)
+	}
+
+	f, err := trns.ReadRootFile(ctx, "document.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, _ := io.ReadAll(f)
+	f.Close()
+	for _, s := range []string{"<script", "onload", "style.css", "foo.png"} {
+		if bytes.Contains(doc, []byte(s)) {
+			t.Errorf("Document should not contain '%s':\n%s", s, doc)
+		}
+	}
+	if !bytes.Contains(doc, []byte(`href="http://example.org/a/other.html"`)) {
+		t.Errorf("Links should point to the original site:\n%s", doc)
+	}
+
+	// Export the imported document again
+	var out bytes.Buffer
+	err = ExportDocument(ctx, NewWriter(&out, false), store, res.DocumentIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []string{}
+	for {
+		rec, err := rd.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, rec.Type())
+	}
+	if strings.Join(types, ",") != "response,resource,resource,resource,metadata" {
+		t.Errorf("Unexpected records %v", types)
+	}
+}
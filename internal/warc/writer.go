@@ -0,0 +1,209 @@
+// Package warc reads and writes files in the WARC format (ISO 28500)
+package warc
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const warcVersion string = "WARC/1.1"
+
+// A Writer writes records to a WARC file. If Compress is set, each record is
+// written as a separate gzip member, so that the file can still be read
+// record by record.
+type Writer struct {
+	w        io.Writer
+	Compress bool
+}
+
+func NewWriter(w io.Writer, compress bool) *Writer {
+	return &Writer{
+		w:        w,
+		Compress: compress,
+	}
+}
+
+// NewRecordID generates a globally unique ID for a WARC record
+func NewRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b[:])
+	return fmt.Sprintf("<urn:uuid:%s-%s-%s-%s-%s>", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+// A Header contains the named fields of a WARC record, in order
+type Header [][2]string
+
+func (h *Header) Add(name, value string) {
+	*h = append(*h, [2]string{name, value})
+}
+
+func (h Header) Get(name string) string {
+	for _, f := range h {
+		if strings.EqualFold(f[0], name) {
+			return f[1]
+		}
+	}
+	return ""
+}
+
+// WriteWarcinfo writes a warcinfo record describing this file
+func (w *Writer) WriteWarcinfo(filename string, fields Header) (string, error) {
+	var b strings.Builder
+	for _, f := range fields {
+		fmt.Fprintf(&b, "%s: %s\r\n", f[0], f[1])
+	}
+
+	id := NewRecordID()
+	var h Header
+	h.Add("WARC-Type", "warcinfo")
+	h.Add("WARC-Record-ID", id)
+	h.Add("WARC-Date", formatDate(time.Now()))
+	if filename != "" {
+		h.Add("WARC-Filename", filename)
+	}
+	h.Add("Content-Type", "application/warc-fields")
+	return id, w.writeRecord(h, nil, strings.NewReader(b.String()))
+}
+
+// WriteResponse writes a response record, wrapping the payload in a minimal HTTP response
+func (w *Writer) WriteResponse(targetURI string, date time.Time, contentType string, payload io.Reader) (string, error) {
+	id := NewRecordID()
+	var h Header
+	h.Add("WARC-Type", "response")
+	h.Add("WARC-Record-ID", id)
+	h.Add("WARC-Date", formatDate(date))
+	h.Add("WARC-Target-URI", targetURI)
+	h.Add("Content-Type", "application/http;msgtype=response")
+
+	httpHeader := func(size int64) []byte {
+		return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", contentType, size))
+	}
+	return id, w.writeRecord(h, httpHeader, payload)
+}
+
+// WriteResource writes a resource record
+func (w *Writer) WriteResource(targetURI string, date time.Time, contentType string, concurrentTo string, payload io.Reader) (string, error) {
+	id := NewRecordID()
+	var h Header
+	h.Add("WARC-Type", "resource")
+	h.Add("WARC-Record-ID", id)
+	h.Add("WARC-Date", formatDate(date))
+	h.Add("WARC-Target-URI", targetURI)
+	if concurrentTo != "" {
+		h.Add("WARC-Concurrent-To", concurrentTo)
+	}
+	if contentType != "" {
+		h.Add("Content-Type", contentType)
+	}
+	return id, w.writeRecord(h, nil, payload)
+}
+
+// WriteMetadata writes a metadata record that refers to another record
+func (w *Writer) WriteMetadata(targetURI string, date time.Time, contentType string, refersTo string, payload io.Reader) (string, error) {
+	id := NewRecordID()
+	var h Header
+	h.Add("WARC-Type", "metadata")
+	h.Add("WARC-Record-ID", id)
+	h.Add("WARC-Date", formatDate(date))
+	h.Add("WARC-Target-URI", targetURI)
+	if refersTo != "" {
+		h.Add("WARC-Refers-To", refersTo)
+	}
+	h.Add("Content-Type", contentType)
+	return id, w.writeRecord(h, nil, payload)
+}
+
+// writeRecord writes a single record. Since the record header has to include
+// the length and digest of the content block, the payload is first copied to
+// a temporary file rather than kept in memory.
+func (w *Writer) writeRecord(h Header, httpHeader func(int64) []byte, payload io.Reader) error {
+	tmp, err := os.CreateTemp("", "warc-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	payloadDigest := sha1.New()
+	size, err := io.Copy(io.MultiWriter(tmp, payloadDigest), payload)
+	if err != nil {
+		return err
+	}
+
+	var prefix []byte
+	if httpHeader != nil {
+		prefix = httpHeader(size)
+	}
+
+	blockDigest := sha1.New()
+	blockDigest.Write(prefix)
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(blockDigest, tmp); err != nil {
+		return err
+	}
+
+	h.Add("WARC-Block-Digest", formatDigest(blockDigest))
+	if httpHeader != nil {
+		h.Add("WARC-Payload-Digest", formatDigest(payloadDigest))
+	}
+	h.Add("Content-Length", fmt.Sprintf("%d", int64(len(prefix))+size))
+
+	var out io.Writer = w.w
+	var gz *gzip.Writer
+	if w.Compress {
+		gz = gzip.NewWriter(w.w)
+		out = gz
+	}
+
+	var b strings.Builder
+	b.WriteString(warcVersion + "\r\n")
+	for _, f := range h {
+		fmt.Fprintf(&b, "%s: %s\r\n", f[0], f[1])
+	}
+	b.WriteString("\r\n")
+	if _, err := io.WriteString(out, b.String()); err != nil {
+		return err
+	}
+	if _, err := out.Write(prefix); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, tmp); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(out, "\r\n\r\n"); err != nil {
+		return err
+	}
+
+	if gz != nil {
+		return gz.Close()
+	}
+	return nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatDigest(h hash.Hash) string {
+	return "sha1:" + base32.StdEncoding.EncodeToString(h.Sum(nil))
+}
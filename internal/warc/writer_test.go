@@ -0,0 +1,268 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// rawRecord is a record parsed from the bytes of a WARC file, without using Reader
+type rawRecord struct {
+	Header Header
+	Block  []byte
+}
+
+// parseRecords splits an uncompressed WARC file into records, and checks the
+// framing of each record
+func parseRecords(t *testing.T, b []byte) []rawRecord {
+	t.Helper()
+	var rv []rawRecord
+	for len(b) > 0 {
+		end := bytes.Index(b, []byte("\r\n\r\n"))
+		if end < 0 {
+			t.Fatalf("Record header is not terminated: %q", b)
+		}
+		lines := strings.Split(string(b[:end]), "\r\n")
+		b = b[end+4:]
+		if lines[0] != "WARC/1.1" {
+			t.Fatalf("Unexpected version line '%s'", lines[0])
+		}
+
+		var rec rawRecord
+		for _, l := range lines[1:] {
+			name, value, ok := strings.Cut(l, ": ")
+			if !ok {
+				t.Fatalf("Malformed header field '%s'", l)
+			}
+			rec.Header.Add(name, value)
+		}
+
+		length, err := strconv.Atoi(rec.Header.Get("Content-Length"))
+		if err != nil || length > len(b) {
+			t.Fatalf("Invalid Content-Length '%s' with %d bytes left", rec.Header.Get("Content-Length"), len(b))
+		}
+		rec.Block = b[:length]
+		b = b[length:]
+		if !bytes.HasPrefix(b, []byte("\r\n\r\n")) {
+			t.Fatalf("Record is not followed by two newlines")
+		}
+		b = b[4:]
+		rv = append(rv, rec)
+	}
+	return rv
+}
+
+var recordIDPattern = regexp.MustCompile(`^<urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}>$`)
+
+func TestWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b, false)
+	date := time.Date(2022, 7, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	infoID, err := w.WriteWarcinfo("test.warc", DefaultWarcinfo("doc-hoarder/test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := "<!DOCTYPE html><title>Test</title>"
+	responseID, err := w.WriteResponse("http://example.org/", date, "text/html", strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resourceID, err := w.WriteResource("http://example.org/style.css", date, "text/css", responseID, strings.NewReader("body {}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs := parseRecords(t, b.Bytes())
+	if len(recs) != 3 {
+		t.Fatalf("Expected three records; got %d", len(recs))
+	}
+	for i, id := range []string{infoID, responseID, resourceID} {
+		if !recordIDPattern.MatchString(id) {
+			t.Errorf("Record ID %s is not a UUID URN", id)
+		}
+		if recs[i].Header.Get("WARC-Record-ID") != id {
+			t.Errorf("Record %d has ID %s; expected %s", i, recs[i].Header.Get("WARC-Record-ID"), id)
+		}
+
+		digest := sha1.Sum(recs[i].Block)
+		if d := "sha1:" + base32.StdEncoding.EncodeToString(digest[:]); recs[i].Header.Get("WARC-Block-Digest") != d {
+			t.Errorf("Record %d has block digest %s; expected %s", i, recs[i].Header.Get("WARC-Block-Digest"), d)
+		}
+	}
+	if infoID == responseID || responseID == resourceID {
+		t.Errorf("Record IDs should be unique")
+	}
+
+	info := recs[0]
+	if info.Header.Get("WARC-Type") != "warcinfo" || info.Header.Get("WARC-Filename") != "test.warc" || info.Header.Get("Content-Type") != "application/warc-fields" {
+		t.Errorf("Unexpected warcinfo header %v", info.Header)
+	}
+	if !bytes.Contains(info.Block, []byte("software: doc-hoarder/test\r\n")) {
+		t.Errorf("Unexpected warcinfo fields %q", info.Block)
+	}
+
+	resp := recs[1]
+	if resp.Header.Get("WARC-Type") != "response" || resp.Header.Get("WARC-Target-URI") != "http://example.org/" || resp.Header.Get("Content-Type") != "application/http;msgtype=response" {
+		t.Errorf("Unexpected response header %v", resp.Header)
+	}
+	if resp.Header.Get("WARC-Date") != "2022-07-01T10:00:00Z" {
+		t.Errorf("Dates should be written in UTC; got '%s'", resp.Header.Get("WARC-Date"))
+	}
+	httpHeader := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: %d\r\n\r\n", len(page))
+	if string(resp.Block) != httpHeader+page {
+		t.Errorf("Unexpected response block %q", resp.Block)
+	}
+	payloadDigest := sha1.Sum([]byte(page))
+	if d := "sha1:" + base32.StdEncoding.EncodeToString(payloadDigest[:]); resp.Header.Get("WARC-Payload-Digest") != d {
+		t.Errorf("Response has payload digest %s; expected %s", resp.Header.Get("WARC-Payload-Digest"), d)
+	}
+
+	res := recs[2]
+	if res.Header.Get("WARC-Type") != "resource" || res.Header.Get("WARC-Target-URI") != "http://example.org/style.css" || res.Header.Get("Content-Type") != "text/css" || res.Header.Get("WARC-Concurrent-To") != responseID {
+		t.Errorf("Unexpected resource header %v", res.Header)
+	}
+	if string(res.Block) != "body {}" || res.Header.Get("WARC-Payload-Digest") != "" {
+		t.Errorf("Resource records should contain only the payload; got %q", res.Block)
+	}
+}
+
+func TestWriterCompressed(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b, true)
+	for _, u := range []string{"http://example.org/a", "http://example.org/b"} {
+		_, err := w.WriteResource(u, time.Now(), "text/plain", "", strings.NewReader(u))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every record is a separate gzip member
+	zr, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"http://example.org/a", "http://example.org/b"} {
+		zr.Multistream(false)
+		member, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs := parseRecords(t, member)
+		if len(recs) != 1 || recs[0].Header.Get("WARC-Target-URI") != u {
+			t.Errorf("Expected one record for %s in a gzip member; got %v", u, recs)
+		}
+		if err := zr.Reset(&b); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	if b.Len() != 0 {
+		t.Errorf("%d bytes left after reading all members", b.Len())
+	}
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	store, err := storage.GetDocStore("fs:" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := store.NewDocumentID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trns, err := store.GetDocument(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	meta := storage.DocumentMeta{
+		Title:       "Test page",
+		URL:         "http://example.org/a/page.html",
+		ContentType: "text/html",
+		Status:      storage.StatusStatic,
+		CaptureDate: date,
+	}
+	meta.Permissions.Owner = "alice"
+	if err := storage.WriteMeta(ctx, trns, meta); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"document.bin":    `<link rel="stylesheet" href="att/t0000000001.css"><img src="att/t0000000002.png">`,
+		"t0000000001.css": "body {}",
+		"t0000000002.png": "not really a png",
+	}
+	for name, contents := range files {
+		var g io.WriteCloser
+		if name == "document.bin" {
+			g, err = trns.WriteRootFile(ctx, name)
+		} else {
+			g, err = trns.WriteAttachment(ctx, name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(g, contents)
+		g.Close()
+	}
+	if err := trns.Commit(ctx, "test export"); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = ExportStore(ctx, NewWriter(&b, false), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := parseRecords(t, b.Bytes())
+	if len(recs) != 4 {
+		t.Fatalf("Expected a response, two resources and a metadata record; got %d records", len(recs))
+	}
+
+	resp := recs[0]
+	responseID := resp.Header.Get("WARC-Record-ID")
+	if resp.Header.Get("WARC-Type") != "response" || resp.Header.Get("WARC-Target-URI") != meta.URL || resp.Header.Get("WARC-Date") != "2022-07-01T12:00:00Z" {
+		t.Errorf("Unexpected response header %v", resp.Header)
+	}
+	if !bytes.HasSuffix(resp.Block, []byte("\r\n\r\n"+files["document.bin"])) {
+		t.Errorf("Unexpected response block %q", resp.Block)
+	}
+
+	for i, att := range []struct {
+		Name, URI, ContentType string
+	}{
+		{"t0000000001.css", "http://example.org/a/att/t0000000001.css", "text/css; charset=utf-8"},
+		{"t0000000002.png", "http://example.org/a/att/t0000000002.png", "image/png"},
+	} {
+		rec := recs[1+i]
+		if rec.Header.Get("WARC-Type") != "resource" || rec.Header.Get("WARC-Target-URI") != att.URI {
+			t.Errorf("Expected a resource record for %s; got %v", att.URI, rec.Header)
+		}
+		if rec.Header.Get("Content-Type") != att.ContentType || rec.Header.Get("WARC-Concurrent-To") != responseID || rec.Header.Get("WARC-Date") != "2022-07-01T12:00:00Z" {
+			t.Errorf("Unexpected resource header %v", rec.Header)
+		}
+		if string(rec.Block) != files[att.Name] {
+			t.Errorf("Unexpected contents %q for %s", rec.Block, att.Name)
+		}
+	}
+
+	md := recs[3]
+	if md.Header.Get("WARC-Type") != "metadata" || md.Header.Get("WARC-Refers-To") != responseID || md.Header.Get("Content-Type") != "application/xml" {
+		t.Errorf("Unexpected metadata header %v", md.Header)
+	}
+	if !bytes.Contains(md.Block, []byte("Test page")) {
+		t.Errorf("Metadata record should contain the document's metadata; got %q", md.Block)
+	}
+}
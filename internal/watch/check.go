@@ -0,0 +1,77 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/capture"
+	"github.com/thijzert/doc-hoarder/internal/doctext"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+)
+
+// Check captures a watched URL again. If the text of the page differs from
+// the previous capture, the new capture is stored as a new document with the
+// same tags, owned by the watch's owner. Otherwise, nothing is stored, and the
+// event records that the page is still the same. If the previous capture was
+// deleted or moved to the trash, Check returns ErrGone.
+func Check(ctx context.Context, st storage.DocStore, w Watch, fetch capture.FetchFunc) (Event, error) {
+	ev := Event{
+		WatchID:    w.ID,
+		URL:        w.URL,
+		Owner:      w.Owner,
+		Date:       time.Now(),
+		DocumentID: w.DocumentID,
+		PreviousID: w.DocumentID,
+	}
+
+	prev, err := st.GetDocument(w.DocumentID)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ev, ErrGone
+	} else if err != nil {
+		return ev, err
+	}
+	defer prev.Rollback()
+	meta, err := storage.ReadMeta(ctx, prev)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && meta.Trashed()) {
+		return ev, ErrGone
+	} else if err != nil {
+		return ev, err
+	}
+	prevText, err := documentText(ctx, prev)
+	if err != nil {
+		return ev, err
+	}
+
+	// The previous capture may have been shared by someone else, so the new
+	// one gets the permissions of any new document of the watch's owner
+	opts := capture.Options{
+		Permissions: storage.Permissions{Owner: w.Owner},
+		Tags:        meta.Tags,
+		Keep: func(ctx context.Context, text string) (bool, error) {
+			return text != prevText, nil
+		},
+	}
+
+	docID, _, err := capture.URL(ctx, st, w.URL, fetch, opts)
+	if err == capture.ErrDiscarded {
+		return ev, nil
+	} else if err != nil {
+		return ev, err
+	}
+
+	ev.Changed = true
+	ev.DocumentID = docID
+	return ev, nil
+}
+
+// documentText extracts the visible text of a document
+func documentText(ctx context.Context, trns storage.DocTransaction) (string, error) {
+	f, err := trns.ReadRootFile(ctx, "document.bin")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return doctext.Extract(f)
+}
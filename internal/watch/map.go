@@ -0,0 +1,237 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxEvents limits the number of events kept per watch
+const maxEvents int = 100
+
+type mapStore struct {
+	Mu       *sync.Mutex
+	Contents *mapContents
+	Filename string
+}
+
+type mapContents struct {
+	Watches map[string]Watch
+	Events  map[string][]Event
+}
+
+func (m mapStore) NewWatch(ctx context.Context, w Watch) (Watch, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	for i := 0; i < 32; i++ {
+		w.ID = NewWatchID()
+		if _, ok := m.Contents.Watches[w.ID]; !ok {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return w, err
+		}
+	}
+	if w.Interval < MinInterval {
+		w.Interval = MinInterval
+	}
+	w.Created = time.Now()
+	w.LastChecked = time.Time{}
+	w.LastChanged = w.Created
+
+	m.Contents.Watches[w.ID] = w
+	return w, m.saveContents(ctx)
+}
+
+func (m mapStore) GetWatch(ctx context.Context, id string) (Watch, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv, ok := m.Contents.Watches[id]
+	if !ok {
+		return rv, ErrNotPresent
+	}
+	return rv, nil
+}
+
+func (m mapStore) GetWatchesForOwner(ctx context.Context, owner string) ([]Watch, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv := []Watch{}
+	for _, w := range m.Contents.Watches {
+		if w.Owner == owner {
+			rv = append(rv, w)
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].URL < rv[j].URL
+	})
+	return rv, nil
+}
+
+func (m mapStore) SetInterval(ctx context.Context, id string, interval time.Duration) (Watch, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	w, ok := m.Contents.Watches[id]
+	if !ok {
+		return w, ErrNotPresent
+	}
+	if interval < MinInterval {
+		interval = MinInterval
+	}
+	w.Interval = interval
+	m.Contents.Watches[id] = w
+	return w, m.saveContents(ctx)
+}
+
+func (m mapStore) DeleteWatch(ctx context.Context, owner, id string) error {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	w, ok := m.Contents.Watches[id]
+	if !ok || w.Owner != owner {
+		return ErrNotPresent
+	}
+	delete(m.Contents.Watches, id)
+	delete(m.Contents.Events, id)
+	return m.saveContents(ctx)
+}
+
+func (m mapStore) Due(ctx context.Context, now time.Time) ([]Watch, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv := []Watch{}
+	for _, w := range m.Contents.Watches {
+		if !w.Disabled && !now.Before(w.NextCheck()) {
+			rv = append(rv, w)
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].NextCheck().Before(rv[j].NextCheck())
+	})
+	return rv, nil
+}
+
+func (m mapStore) RecordCheck(ctx context.Context, id string, ev Event, checkErr error) error {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	w, ok := m.Contents.Watches[id]
+	if !ok {
+		return ErrNotPresent
+	}
+	w.LastChecked = ev.Date
+	if checkErr != nil {
+		w.LastError = checkErr.Error()
+		w.Disabled = errors.Is(checkErr, ErrGone)
+		m.Contents.Watches[id] = w
+		return m.saveContents(ctx)
+	}
+
+	w.LastError = ""
+	if ev.Changed {
+		w.LastChanged = ev.Date
+		w.DocumentID = ev.DocumentID
+	}
+	m.Contents.Watches[id] = w
+
+	events := append(m.Contents.Events[id], ev)
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+	m.Contents.Events[id] = events
+	return m.saveContents(ctx)
+}
+
+func (m mapStore) GetEvents(ctx context.Context, owner string, limit int) ([]Event, error) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+
+	rv := []Event{}
+	for id, events := range m.Contents.Events {
+		if w, ok := m.Contents.Watches[id]; !ok || w.Owner != owner {
+			continue
+		}
+		rv = append(rv, events...)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Date.After(rv[j].Date)
+	})
+	if limit > 0 && len(rv) > limit {
+		rv = rv[:limit]
+	}
+	return rv, nil
+}
+
+func (m mapStore) saveContents(ctx context.Context) error {
+	if m.Filename == "" {
+		return nil
+	}
+
+	// Save map to filesystem
+	f, err := os.Create(m.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	en := json.NewEncoder(f)
+	en.SetIndent("", "\t")
+	return en.Encode(m.Contents)
+}
+
+func newContents() *mapContents {
+	return &mapContents{
+		Watches: make(map[string]Watch),
+		Events:  make(map[string][]Event),
+	}
+}
+
+func init() {
+	newMapStore := func(_descriptor string) (Store, error) {
+		return mapStore{
+			Mu:       &sync.Mutex{},
+			Contents: newContents(),
+		}, nil
+	}
+	RegisterStorageMethod("", newMapStore)
+	RegisterStorageMethod("map", newMapStore)
+	RegisterStorageMethod("memory", newMapStore)
+
+	newFSStore := func(fileName string) (Store, error) {
+		rv := mapStore{
+			Mu:       &sync.Mutex{},
+			Contents: newContents(),
+			Filename: fileName,
+		}
+
+		f, err := os.Open(fileName)
+		if errors.Is(err, fs.ErrNotExist) {
+			return rv, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		err = dec.Decode(rv.Contents)
+		if rv.Contents.Watches == nil {
+			rv.Contents.Watches = make(map[string]Watch)
+		}
+		if rv.Contents.Events == nil {
+			rv.Contents.Events = make(map[string][]Event)
+		}
+		return rv, err
+	}
+	RegisterStorageMethod("fs", newFSStore)
+	RegisterStorageMethod("file", newFSStore)
+	RegisterStorageMethod("json", newFSStore)
+}
@@ -0,0 +1,148 @@
+// Package watch keeps track of web pages that are captured again at regular
+// intervals, and of the changes found in them.
+package watch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotPresent is returned when a watch does not exist
+var ErrNotPresent = errors.New("watch not present")
+
+// ErrGone is returned when the watched document was deleted or moved to the
+// trash. Watches are disabled when their check returns it.
+var ErrGone = errors.New("the watched document is no longer available")
+
+// MinInterval is the shortest interval at which a page can be captured
+const MinInterval time.Duration = 15 * time.Minute
+
+// A Watch periodically captures a URL
+type Watch struct {
+	ID    string
+	URL   string
+	Owner string
+
+	// DocumentID is the most recent capture of the URL
+	DocumentID string
+	Interval   time.Duration
+
+	Created     time.Time
+	LastChecked time.Time
+	LastChanged time.Time
+
+	// LastError is the reason the most recent check failed, if it did
+	LastError string `json:",omitempty"`
+
+	// Disabled watches are no longer checked, because their document is gone
+	Disabled bool `json:",omitempty"`
+}
+
+// NextCheck returns the moment this watch should be checked again
+func (w Watch) NextCheck() time.Time {
+	if w.LastChecked.IsZero() {
+		return w.Created.Add(w.Interval)
+	}
+	return w.LastChecked.Add(w.Interval)
+}
+
+// IntervalName describes the interval of this watch, e.g. "3 days"
+func (w Watch) IntervalName() string {
+	units := []struct {
+		d    time.Duration
+		name string
+	}{
+		{7 * 24 * time.Hour, "week"},
+		{24 * time.Hour, "day"},
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+	}
+	for _, u := range units {
+		if w.Interval < u.d || w.Interval%u.d != 0 {
+			continue
+		}
+		if n := int(w.Interval / u.d); n != 1 {
+			return fmt.Sprintf("%d %ss", n, u.name)
+		}
+		return u.name
+	}
+	return w.Interval.String()
+}
+
+// An Event is the result of checking a watch
+type Event struct {
+	WatchID string
+	URL     string
+	Owner   string
+	Date    time.Time
+
+	// Changed is true if a new capture was stored; otherwise the page was
+	// still the same as the previous capture.
+	Changed bool
+
+	// DocumentID is the capture that was current after this check
+	DocumentID string
+	// PreviousID is the capture that was current before this check
+	PreviousID string `json:",omitempty"`
+}
+
+// NewWatchID generates a random ID for a watch
+func NewWatchID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+type Store interface {
+	// NewWatch stores a new watch. It assigns the watch's ID and creation date.
+	NewWatch(context.Context, Watch) (Watch, error)
+	GetWatch(context.Context, string) (Watch, error)
+	GetWatchesForOwner(context.Context, string) ([]Watch, error)
+
+	// SetInterval changes how often a page is checked
+	SetInterval(ctx context.Context, id string, interval time.Duration) (Watch, error)
+	DeleteWatch(ctx context.Context, owner, id string) error
+
+	// Due lists all enabled watches that should be checked at this moment
+	Due(context.Context, time.Time) ([]Watch, error)
+
+	// RecordCheck stores the result of a check, and updates the watch
+	// accordingly. A check that failed with ErrGone disables the watch.
+	RecordCheck(ctx context.Context, id string, ev Event, checkErr error) error
+
+	// GetEvents lists the most recent events for an owner, newest first
+	GetEvents(ctx context.Context, owner string, limit int) ([]Event, error)
+}
+
+type StorageMethod func(string) (Store, error)
+
+var allStorageMethods map[string]StorageMethod
+
+func RegisterStorageMethod(name string, f StorageMethod) {
+	if allStorageMethods == nil {
+		allStorageMethods = make(map[string]StorageMethod)
+	}
+	allStorageMethods[name] = f
+}
+
+func GetStore(descriptor string) (Store, error) {
+	i := strings.IndexRune(descriptor, ':')
+	name := ""
+	if i >= 0 {
+		name = descriptor[:i]
+		descriptor = descriptor[i+1:]
+	}
+	if allStorageMethods == nil {
+		return nil, fmt.Errorf("no storage backends have been initialized")
+	}
+
+	if f, ok := allStorageMethods[name]; ok {
+		return f(descriptor)
+	}
+	return nil, fmt.Errorf("storage backend '%s' not registered", name)
+}
@@ -0,0 +1,175 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thijzert/doc-hoarder/internal/capture"
+	"github.com/thijzert/doc-hoarder/internal/fetch"
+	"github.com/thijzert/doc-hoarder/internal/storage"
+	_ "github.com/thijzert/doc-hoarder/internal/storage/gitstore"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+
+	body, nonce := "Our terms", 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/terms" {
+			http.NotFound(w, r)
+			return
+		}
+		// The script changes on every request, but isn't part of the captured text
+		nonce++
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><head><title>Terms</title><script>var nonce = %d;</script></head><body><p>%s</p></body></html>", nonce, body)
+	}))
+	defer srv.Close()
+	allow, _ := fetch.ParseNetworks("127.0.0.0/8, ::1")
+	fetcher := fetch.New("doc-hoarder-test", allow)
+
+	for _, scheme := range []string{"fs", "git"} {
+		t.Run(scheme, func(t *testing.T) {
+			dir := t.TempDir()
+			st, err := storage.GetDocStore(scheme + ":" + dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			watches, err := GetStore("file:" + path.Join(t.TempDir(), "watches.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body = "Our terms"
+			perms := storage.Permissions{Owner: "alice", ReadUsers: []string{"bob"}}
+			docID, _, err := capture.URL(ctx, st, srv.URL+"/terms", fetcher.Fetch, capture.Options{Permissions: perms, Tags: []string{"legal"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w, err := watches.NewWatch(ctx, Watch{URL: srv.URL + "/terms", Owner: "alice", DocumentID: docID, Interval: time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if due, _ := watches.Due(ctx, time.Now()); len(due) != 0 {
+				t.Errorf("No watches should be due yet; got %v", due)
+			}
+			if due, _ := watches.Due(ctx, time.Now().Add(2*time.Hour)); len(due) != 1 {
+				t.Errorf("Expected one due watch; got %v", due)
+			}
+
+			before := listFiles(t, dir)
+			ev, err := Check(ctx, st, w, fetcher.Fetch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Changed || ev.DocumentID != docID {
+				t.Errorf("The page should still be the same; got %+v", ev)
+			}
+			if after := listFiles(t, dir); !reflect.DeepEqual(before, after) {
+				t.Errorf("An unchanged page should leave the store untouched; before: %v; after: %v", before, after)
+			}
+			err = watches.RecordCheck(ctx, w.ID, ev, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body = "Our new terms"
+			w, _ = watches.GetWatch(ctx, w.ID)
+			ev, err = Check(ctx, st, w, fetcher.Fetch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ev.Changed || ev.DocumentID == docID || ev.PreviousID != docID {
+				t.Errorf("Expected a new capture; got %+v", ev)
+			}
+			err = watches.RecordCheck(ctx, w.ID, ev, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			trns, err := st.GetDocument(ev.DocumentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := storage.ReadMeta(ctx, trns)
+			trns.Rollback()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Permissions.Owner != "alice" || len(meta.Permissions.ReadUsers) != 0 || len(meta.Tags) != 1 {
+				t.Errorf("The new capture should inherit tags, but get the owner's default permissions; got %+v", meta)
+			}
+
+			w, _ = watches.GetWatch(ctx, w.ID)
+			if w.DocumentID != ev.DocumentID {
+				t.Errorf("The watch should follow the most recent capture")
+			}
+			events, err := watches.GetEvents(ctx, "alice", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 2 || !events[0].Changed || events[1].Changed {
+				t.Errorf("Unexpected events %+v", events)
+			}
+
+			// Watches of documents that are moved to the trash are disabled
+			err = storage.TrashDocument(ctx, st, w.DocumentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ev, err = Check(ctx, st, w, fetcher.Fetch)
+			if err != ErrGone {
+				t.Errorf("Expected ErrGone for a trashed document; got %v", err)
+			}
+			err = watches.RecordCheck(ctx, w.ID, ev, err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, _ = watches.GetWatch(ctx, w.ID)
+			if !w.Disabled {
+				t.Errorf("The watch of a trashed document should be disabled")
+			}
+			if due, _ := watches.Due(ctx, time.Now().Add(24*time.Hour)); len(due) != 0 {
+				t.Errorf("Disabled watches should not be due; got %v", due)
+			}
+
+			// The same goes for documents that are deleted
+			err = st.DeleteDocument(ctx, w.DocumentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Check(ctx, st, w, fetcher.Fetch); err != ErrGone {
+				t.Errorf("Expected ErrGone for a deleted document; got %v", err)
+			}
+		})
+	}
+}
+
+// listFiles returns the size of every file in a directory tree
+func listFiles(t *testing.T, dir string) map[string]int64 {
+	rv := make(map[string]int64)
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rv[name] = fi.Size()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rv
+}
@@ -87,43 +87,78 @@
 	};
 
 	const UPLOAD_CHUNK_SIZE = 512000;
+	const UPLOAD_RETRIES = 3;
+
+	const sha256 = async (blob) => {
+		let digest = await crypto.subtle.digest("SHA-256", await blob.arrayBuffer());
+		return Array.from(new Uint8Array(digest)).map(b => b.toString(16).padStart(2, "0")).join("");
+	};
+
+	// uploadFile sends a file in chunks. Every chunk states its offset and
+	// checksum; the last one also has the size and checksum of the whole file.
+	// If a chunk fails, ask the server how much it received and resume from there.
 	const uploadFile = async (url, paramName, filename, contents, formParameters) => {
 		if ( !(contents instanceof Blob) ) {
 			contents = new Blob([contents]);
 		}
+		const fileHash = await sha256(contents);
 
-		let idx = 0;
-		do {
-			let uploadForm = new FormData();
-			if ( idx === 0 ) {
-				uploadForm.append("truncate", "1")
-			}
-
+		const newForm = () => {
+			let form = new FormData();
 			for ( let k in formParameters ) {
 				if ( formParameters.hasOwnProperty(k) ) {
-					uploadForm.append(k, formParameters[k]);
+					form.append(k, formParameters[k]);
 				}
 			}
-			uploadForm.append(paramName, new File([contents.slice(idx, idx+UPLOAD_CHUNK_SIZE)], document.title + ".html"));
+			return form;
+		};
+		const post = async (addr, form) => {
+			let resp = await fetch(addr, {method: "POST", body: form});
+			let result = await resp.json();
+			if ( !resp.ok || !result.ok ) {
+				throw result;
+			}
+			return result;
+		};
 
-			let upload = await fetch(url, {
-				method: "POST",
-				body: uploadForm,
-			});
-			let uploadResult = await upload.json();
-			if ( !uploadResult.ok ) {
-				console.error(uploadResult);
+		let idx = 0;
+		let failures = 0;
+		for (;;) {
+			let chunk = contents.slice(idx, idx+UPLOAD_CHUNK_SIZE);
+			let uploadForm = newForm();
+			uploadForm.append("offset", idx);
+			uploadForm.append("chunk_sha256", await sha256(chunk));
+			if ( idx + chunk.size >= contents.size ) {
+				uploadForm.append("complete", "1");
+				uploadForm.append("size", contents.size);
+				uploadForm.append("sha256", fileHash);
+			}
+			uploadForm.append(paramName, new File([chunk], filename));
+
+			try {
+				let uploadResult = await post(url, uploadForm);
+				if ( uploadResult.complete ) {
+					return uploadResult;
+				}
+				idx = uploadResult.received;
+			} catch ( e ) {
+				console.error(e);
+				if ( ++failures > UPLOAD_RETRIES ) {
+					throw e;
+				}
+				let status = await post(new URL("upload-status", url), newForm());
+				idx = status.received;
 			}
-			idx += UPLOAD_CHUNK_SIZE;
-		} while ( idx < contents.size );
+		}
 	}
 
-	const flatten = async (txid, doc_id) => {
+	const flatten = async (txid, doc_id, tags, api_key) => {
 		const BASE_URL = "https://xxxxxxxxxxxxxxxxxxxxxxxx";
 
 		const postDoc = async (addr, data, as = "json") => {
 			let req = {method: "POST", body: new FormData()}
 			req.body.append("txid", txid);
+			req.body.append("api_key", api_key);
 			for ( let k in data ) {
 				if ( data.hasOwnProperty(k) ) {
 					req.body.append(k, data[k]);
@@ -218,7 +253,7 @@
 				throw "unknown mime type '" + blob.type + "'";
 			}
 
-			att_id = await postDoc("api/new-attachment", {ext: fileExt});
+			att_id = await postDoc("api/new-attachment", {ext: fileExt, url: url.toString()});
 			if ( !att_id.attachment_id ) {
 				console.error(att_id);
 			}
@@ -228,6 +263,7 @@
 
 			await uploadFile(BASE_URL + "api/upload-attachment", "attachment", filename, blob, {
 				"txid": txid,
+				"api_key": api_key,
 				"att_id": att_id,
 			});
 
@@ -402,7 +438,7 @@
 				stylesheet = new CSSStyleSheet();
 				stylesheet.replaceSync(style_cnt);
 			} else {
-				att_id = await postDoc("api/new-attachment", {ext: "css"});
+				att_id = await postDoc("api/new-attachment", {ext: "css", url: stylesheet_href ? url.toString() : ""});
 				filename = att_id.filename;
 				att_id = att_id.attachment_id;
 				cssAttachments[att_key] = filename;
@@ -419,6 +455,7 @@
 
 			await uploadFile(BASE_URL + "api/upload-attachment", "attachment", filename, attcnt, {
 				"txid": txid,
+				"api_key": api_key,
 				"att_id": att_id,
 			});
 
@@ -527,6 +564,7 @@
 
 		await uploadFile(BASE_URL + "api/upload-draft", "document", document.title + ".html", contents, {
 			"txid": txid,
+			"api_key": api_key,
 			"doc_id": doc_id,
 		});
 
@@ -544,6 +582,7 @@
 			doc_title: document.title,
 			doc_author: "", // TODO
 			icon_id: icon_id,
+			tags: tags || "",
 			log_message: "Saved page from web extension",
 		});
 		if ( !finalize.ok ) { console.error(finalize); }
@@ -560,7 +599,7 @@
 	browser.runtime.onMessage.addListener(async (message) => {
 		console.log("Got message", message);
 		if (message.command === "flatten") {
-			return await flatten(message.txid, message.doc_id);
+			return await flatten(message.txid, message.doc_id, message.tags, message.api_key);
 		}
 	});
 
@@ -93,6 +93,8 @@ const listenForClicks = () => {
 				command: "flatten",
 				doc_id: txid.id,
 				txid: txid.txid,
+				api_key: apikeyInput.value,
+				tags: document.getElementById("tags").value,
 			});
 
 			if ( rv ) {
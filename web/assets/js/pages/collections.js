@@ -0,0 +1,98 @@
+
+const post = async (endpoint, fields) => {
+	let form = fields instanceof FormData ? fields : new FormData();
+	if ( !(fields instanceof FormData) ) {
+		for ( let k in fields ) {
+			form.set(k, fields[k]);
+		}
+	}
+	let rq = await fetch(endpoint, {
+		method: "POST",
+		body: form,
+	});
+	if ( !rq.ok ) {
+		throw rq;
+	}
+	return await rq.json();
+};
+
+// submitForm posts a form to an API endpoint, and reports the result in its status field
+const submitForm = (id, endpoint, after) => {
+	const form = document.getElementById(id);
+	if ( !form ) {
+		return;
+	}
+	const status = form.querySelector(".-status");
+
+	form.addEventListener("submit", async (e) => {
+		e.preventDefault();
+
+		try {
+			let data = new FormData(form);
+			if ( form.dataset.collectionId ) {
+				data.set("collection_id", form.dataset.collectionId);
+			}
+			let res = await post(endpoint, data);
+			if ( status ) {
+				status.textContent = "Saved";
+			}
+			if ( after ) {
+				after(res);
+			}
+		} catch ( e ) {
+			console.error(e);
+			if ( status ) {
+				status.textContent = "Error saving collection";
+			}
+		}
+	});
+};
+
+submitForm("frm-new-collection", "api/collections/new", (res) => {
+	location.href = res.url;
+});
+submitForm("frm-collection", "api/collections/update");
+submitForm("frm-collection-permissions", "api/collections/permissions");
+
+document.addEventListener("click", async (e) => {
+	if ( !e.target ) {
+		return;
+	}
+
+	try {
+		if ( e.target.classList.contains("-js-move") ) {
+			await post("api/collections/move-document", {collection_id: e.target.dataset.collectionId, doc_id: e.target.dataset.docId, offset: e.target.dataset.offset});
+			let li = e.target.closest("li");
+			if ( e.target.dataset.offset < 0 && li.previousElementSibling ) {
+				li.parentNode.insertBefore(li, li.previousElementSibling);
+			} else if ( e.target.dataset.offset > 0 && li.nextElementSibling ) {
+				li.parentNode.insertBefore(li.nextElementSibling, li);
+			}
+		} else if ( e.target.classList.contains("-js-remove") ) {
+			await post("api/collections/remove-document", {collection_id: e.target.dataset.collectionId, doc_id: e.target.dataset.docId});
+			e.target.closest("li").remove();
+		} else if ( e.target.classList.contains("-js-delete-collection") ) {
+			if ( !confirm("Delete this collection? The documents in it will not be deleted.") ) {
+				return;
+			}
+			await post("api/collections/delete", {collection_id: e.target.dataset.collectionId});
+			location.href = "collections";
+		}
+	} catch ( e ) {
+		console.error(e);
+	}
+});
+
+document.addEventListener("change", async (e) => {
+	if ( !e.target || !e.target.classList.contains("-js-collect") || !e.target.value ) {
+		return;
+	}
+
+	try {
+		await post("api/collections/add-document", {collection_id: e.target.value, doc_id: e.target.dataset.docId});
+		e.target.selectedOptions[0].disabled = true;
+	} catch ( e ) {
+		console.error(e);
+	}
+	e.target.value = "";
+});
@@ -0,0 +1,31 @@
+
+(async () => {
+	const form = document.getElementById("frm-details");
+	const status = form.querySelector(".-status");
+
+	form.addEventListener("submit", async (e) => {
+		e.preventDefault();
+
+		try {
+			let data = new FormData(form);
+			data.set("doc_id", form.dataset.docId);
+			let rq = await fetch("api/documents/meta", {
+				method: "POST",
+				body: data,
+			});
+			if ( !rq.ok ) {
+				throw rq;
+			}
+
+			let res = await rq.json();
+			if ( res.changed.length == 0 ) {
+				status.textContent = "No changes";
+			} else {
+				location.reload();
+			}
+		} catch ( e ) {
+			console.error(e);
+			status.textContent = "Error saving document";
+		}
+	});
+})()
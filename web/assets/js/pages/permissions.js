@@ -0,0 +1,27 @@
+
+(async () => {
+	const form = document.getElementById("frm-permissions");
+	const status = form.querySelector(".-status");
+
+	form.addEventListener("submit", async (e) => {
+		e.preventDefault();
+
+		try {
+			let data = new FormData(form);
+			data.set("doc_id", form.dataset.docId);
+			let rq = await fetch("api/documents/permissions", {
+				method: "POST",
+				body: data,
+			});
+			if ( !rq.ok ) {
+				throw rq;
+			}
+
+			await rq.json();
+			status.textContent = "Saved";
+		} catch ( e ) {
+			console.error(e);
+			status.textContent = "Error saving permissions";
+		}
+	});
+})()
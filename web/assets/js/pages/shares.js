@@ -0,0 +1,50 @@
+
+const post = async (endpoint, fields) => {
+	let form = fields instanceof FormData ? fields : new FormData();
+	if ( !(fields instanceof FormData) ) {
+		for ( let k in fields ) {
+			form.set(k, fields[k]);
+		}
+	}
+	let rq = await fetch(endpoint, {
+		method: "POST",
+		body: form,
+	});
+	if ( !rq.ok ) {
+		throw rq;
+	}
+	return await rq.json();
+};
+
+document.addEventListener("click", async (e) => {
+	if ( !e.target ) {
+		return;
+	}
+
+	if ( e.target.classList.contains("-js-revoke-share") ) {
+		try {
+			await post("api/documents/revoke-share", {share_id: e.target.dataset.shareId});
+			e.target.closest("li").remove();
+		} catch ( e ) {
+			console.error(e);
+		}
+	} else if ( e.target.matches("input.-url") ) {
+		e.target.select();
+	}
+});
+
+const newShareForm = document.getElementById("frm-new-share");
+if ( newShareForm ) {
+	newShareForm.addEventListener("submit", async (e) => {
+		e.preventDefault();
+
+		try {
+			let data = new FormData(newShareForm);
+			data.set("doc_id", newShareForm.dataset.docId);
+			await post("api/documents/share", data);
+			location.reload(); // TODO: just add the new link to the list
+		} catch ( e ) {
+			console.error(e);
+		}
+	});
+}
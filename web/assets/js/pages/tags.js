@@ -0,0 +1,43 @@
+
+const post = async (endpoint, fields) => {
+	let form = new FormData();
+	for ( let k in fields ) {
+		form.set(k, fields[k]);
+	}
+	let rq = await fetch(endpoint, {
+		method: "POST",
+		body: form,
+	});
+	if ( !rq.ok ) {
+		throw rq;
+	}
+	return await rq.json();
+};
+
+document.addEventListener("click", async (e) => {
+	if ( !e.target || !e.target.classList.contains("-js-edit-tags") ) {
+		return;
+	}
+
+	let tags = prompt("Tags, separated by commas", e.target.dataset.tags);
+	if ( tags === null ) {
+		return;
+	}
+
+	try {
+		let res = await post("api/documents/tags", {doc_id: e.target.dataset.docId, tags: tags});
+		e.target.dataset.tags = res.tags.join(", ");
+
+		let list = e.target.closest(".doc-tags");
+		list.querySelectorAll(".-tag").forEach(a => a.remove());
+		for ( let tag of res.tags ) {
+			let a = document.createElement("a");
+			a.classList.add("-tag");
+			a.href = "./?tag=" + encodeURIComponent(tag);
+			a.textContent = tag;
+			list.insertBefore(a, e.target);
+		}
+	} catch ( e ) {
+		console.error(e);
+	}
+});
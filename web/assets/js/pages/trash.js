@@ -0,0 +1,39 @@
+
+const post = async (endpoint, fields) => {
+	let form = new FormData();
+	for ( let k in fields ) {
+		form.set(k, fields[k]);
+	}
+	let rq = await fetch(endpoint, {
+		method: "POST",
+		body: form,
+	});
+	if ( !rq.ok ) {
+		throw rq;
+	}
+	return await rq.json();
+};
+
+document.addEventListener("click", async (e) => {
+	if ( !e.target ) {
+		return;
+	}
+
+	try {
+		if ( e.target.classList.contains("-js-trash") ) {
+			await post("api/documents/trash", {doc_id: e.target.dataset.docId});
+			e.target.closest("li").remove();
+		} else if ( e.target.classList.contains("-js-restore") ) {
+			await post("api/documents/restore", {doc_id: e.target.dataset.docId});
+			e.target.closest("li").remove();
+		} else if ( e.target.classList.contains("-js-empty-trash") ) {
+			if ( !confirm("Permanently delete all documents in the trash?") ) {
+				return;
+			}
+			await post("api/documents/empty-trash", {});
+			location.reload();
+		}
+	} catch ( e ) {
+		console.error(e);
+	}
+});
@@ -0,0 +1,49 @@
+
+const post = async (endpoint, fields) => {
+	let form = new FormData();
+	for ( let k in fields ) {
+		form.set(k, fields[k]);
+	}
+	let rq = await fetch(endpoint, {
+		method: "POST",
+		body: form,
+	});
+	if ( !rq.ok ) {
+		throw rq;
+	}
+	return await rq.json();
+};
+
+document.addEventListener("click", async (e) => {
+	if ( !e.target || !e.target.classList.contains("-js-unwatch") ) {
+		return;
+	}
+
+	try {
+		await post("api/documents/unwatch", {watch_id: e.target.dataset.watchId});
+		let li = e.target.closest("li");
+		if ( li ) {
+			li.remove();
+		} else {
+			location.reload();
+		}
+	} catch ( e ) {
+		console.error(e);
+	}
+});
+
+document.addEventListener("submit", async (e) => {
+	if ( !e.target || e.target.id != "frm-watch" ) {
+		return;
+	}
+	e.preventDefault();
+
+	const status = e.target.querySelector(".-status");
+	try {
+		let res = await post("api/documents/watch", {doc_id: e.target.dataset.docId, interval: e.target.elements["interval"].value});
+		status.textContent = "Next check: " + (new Date(res.next_check)).toLocaleString();
+	} catch ( e ) {
+		console.error(e);
+		status.textContent = "Error saving watch";
+	}
+});
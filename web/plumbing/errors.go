@@ -36,6 +36,7 @@ var Forbidden = weberrors.Forbidden             // TODO: remove
 type httpRedirect struct {
 	Code     int
 	Location string
+	Header   http.Header
 }
 
 func (httpRedirect) Error() string { return "redirect" }
@@ -50,5 +51,17 @@ func (httpRedirect) ErrorMessage() (string, string) {
 }
 
 func Redirect(code int, location string) error {
-	return httpRedirect{code, location}
+	return httpRedirect{code, location, nil}
+}
+
+// RedirectWithHeader works like Redirect, but sets additional headers on the response
+func RedirectWithHeader(code int, location string, header http.Header) error {
+	return httpRedirect{code, location, header}
+}
+
+func (r httpRedirect) setHeaders(w http.ResponseWriter) {
+	for k, vs := range r.Header {
+		w.Header()[k] = vs
+	}
+	w.Header().Set("Location", r.Location)
 }
@@ -55,3 +55,39 @@ func (e errForbidden) ErrorMessage() (string, string) { return "forbidden", e.Me
 func Forbidden(format string, elems ...interface{}) error {
 	return errForbidden{fmt.Sprintf(format, elems...)}
 }
+
+type errGone struct {
+	Message string
+}
+
+func (errGone) Error() string                    { return "gone" }
+func (errGone) StatusCode() int                  { return 410 }
+func (e errGone) ErrorMessage() (string, string) { return "gone", e.Message }
+
+func Gone(format string, elems ...interface{}) error {
+	return errGone{fmt.Sprintf(format, elems...)}
+}
+
+type errBadGateway struct {
+	Message string
+}
+
+func (errBadGateway) Error() string                    { return "bad gateway" }
+func (errBadGateway) StatusCode() int                  { return 502 }
+func (e errBadGateway) ErrorMessage() (string, string) { return "bad gateway", e.Message }
+
+func BadGateway(format string, elems ...interface{}) error {
+	return errBadGateway{fmt.Sprintf(format, elems...)}
+}
+
+type errConflict struct {
+	Message string
+}
+
+func (errConflict) Error() string                    { return "conflict" }
+func (errConflict) StatusCode() int                  { return 409 }
+func (e errConflict) ErrorMessage() (string, string) { return "conflict", e.Message }
+
+func Conflict(format string, elems ...interface{}) error {
+	return errConflict{fmt.Sprintf(format, elems...)}
+}
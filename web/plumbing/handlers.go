@@ -2,14 +2,18 @@ package plumbing
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/thijzert/doc-hoarder/web/plumbing/login"
 	"github.com/thijzert/doc-hoarder/web/plumbing/sessions"
@@ -53,6 +57,10 @@ func (j jsonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		bl.WriteTo(w)
 		return
 	}
+	if st, ok := rv.(Stream); ok {
+		st.ServeHTTP(w, r)
+		return
+	}
 
 	j.asJson(w, code, rv)
 }
@@ -63,7 +71,7 @@ func (j jsonHandler) HTTPError(w http.ResponseWriter, r *http.Request, err error
 		code = sc.StatusCode()
 	}
 	if red, ok := err.(httpRedirect); ok {
-		w.Header().Set("Location", red.Location)
+		red.setHeaders(w)
 	}
 
 	rrv := jsonErr{"internal server error", "an internal error has occurred"}
@@ -114,6 +122,130 @@ func (bl Blob) WriteTo(w http.ResponseWriter) {
 	w.Write(bl.Contents)
 }
 
+// A Stream is a response that is read from a file or another io.Reader,
+// rather than held in memory. If the contents can seek, conditional requests
+// and byte ranges are supported; otherwise only conditional requests are.
+type Stream struct {
+	ContentType string
+	Contents    io.Reader
+	Header      http.Header
+
+	// ModTime and ETag identify the version of the contents. Either can be
+	// left empty.
+	ModTime time.Time
+	ETag    string
+
+	// Immutable marks contents that never change, so that browsers can cache
+	// them indefinitely. Shared caches are not allowed to store them, as they
+	// may be private.
+	Immutable bool
+}
+
+// NewStream creates a Stream for a file opened from disk or a document store
+func NewStream(contentType string, f io.Reader) Stream {
+	rv := Stream{
+		ContentType: contentType,
+		Contents:    f,
+	}
+	if st, ok := f.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if fi, err := st.Stat(); err == nil {
+			rv.ModTime = fi.ModTime()
+			rv.ETag = fmt.Sprintf("\"%x-%x\"", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	if et, ok := f.(interface{ ETag() string }); ok {
+		rv.ETag = "\"" + et.ETag() + "\""
+	}
+	return rv
+}
+
+// BytesStream creates a Stream for contents that are already in memory. Its
+// entity tag is derived from the contents.
+func BytesStream(contentType string, contents []byte) Stream {
+	h := sha256.Sum256(contents)
+	return Stream{
+		ContentType: contentType,
+		Contents:    bytes.NewReader(contents),
+		ETag:        "\"" + hex.EncodeToString(h[:12]) + "\"",
+	}
+}
+
+func (st Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c, ok := st.Contents.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if st.ContentType != "" {
+		w.Header().Set("Content-Type", st.ContentType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if st.ETag != "" {
+		w.Header().Set("ETag", st.ETag)
+	}
+	if st.Immutable {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	for k, vs := range st.Header {
+		w.Header()[k] = vs
+	}
+
+	if rs, ok := st.Contents.(io.ReadSeeker); ok {
+		// Some contents, like git blobs, are decompressed from the start
+		// again for every range that lies before the previous one. Requests
+		// for multiple ranges get the full contents instead, as allowed by
+		// RFC 7233.
+		if strings.Contains(r.Header.Get("Range"), ",") {
+			r = r.Clone(r.Context())
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", st.ModTime, rs)
+		return
+	}
+
+	// Without seeking, only conditional requests can be supported
+	if st.notModified(r) {
+		h := w.Header()
+		delete(h, "Content-Type")
+		delete(h, "Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if !st.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", st.ModTime.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Method != http.MethodHead {
+		io.Copy(w, st.Contents)
+	}
+}
+
+// notModified checks if the client already has this version of the contents
+func (st Stream) notModified(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if st.ETag == "" {
+			return false
+		}
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(st.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !st.ModTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !st.ModTime.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
 type htmlHandler struct {
 	Handler      Handler
 	TemplateName string
@@ -147,6 +279,10 @@ func (h htmlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		bl.WriteTo(w)
 		return
 	}
+	if st, ok := tpData.PageData.(Stream); ok {
+		st.ServeHTTP(w, r)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/html")
 
@@ -178,7 +314,7 @@ func (h htmlHandler) HTTPError(w http.ResponseWriter, r *http.Request, err error
 	}
 
 	if red, ok := err.(httpRedirect); ok {
-		w.Header().Set("Location", red.Location)
+		red.setHeaders(w)
 	}
 
 	npd := struct {
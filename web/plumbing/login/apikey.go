@@ -101,16 +101,29 @@ func (k keyMuster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if k.scope != "" {
 		if apikey.Scope != k.scope {
 			k.HTTPError(w, r, errScopeMismatch{})
+			return
 		}
 	}
 
 	// Store the user data in the request, and pass it to the next handler
 	ctx = context.WithValue(ctx, loginKey, &user)
+	ctx = context.WithValue(ctx, apiKeyKey, apikey)
 	r = r.WithContext(ctx)
 
 	k.h.ServeHTTP(w, r)
 }
 
+var apiKeyKey loginKeyType = 3
+
+// GetAPIKey returns the API key that was used to authenticate a request
+func GetAPIKey(r *http.Request) (APIKey, bool) {
+	ctx := r.Context()
+	if k, ok := ctx.Value(apiKeyKey).(APIKey); ok {
+		return k, true
+	}
+	return APIKey{}, false
+}
+
 func (k keyMuster) HTTPError(w http.ResponseWriter, r *http.Request, err error) {
 	if erh, ok := k.h.(weberrors.ErrorHandler); ok {
 		erh.HTTPError(w, r, err)
@@ -26,10 +26,13 @@ type UserID string
 
 type User struct {
 	ID    UserID
-	Dirty bool `json:"-",xml:"-"`
+	Dirty bool `json:"-" xml:"-"`
 
 	FullName  string
 	GivenName string
+
+	// Groups lists the groups this user is a member of, as reported by the login provider
+	Groups []string `json:",omitempty"`
 }
 
 type Store interface {
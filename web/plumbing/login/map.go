@@ -11,7 +11,7 @@ import (
 )
 
 type mapStore struct {
-	Mu       sync.Mutex
+	Mu       *sync.Mutex
 	Contents map[UserID]User
 	Filename string
 	APIKeys  map[KeyID]APIKey
@@ -135,7 +135,9 @@ func (m mapStore) DisableAPIKey(ctx context.Context, userID UserID, id KeyID) er
 func init() {
 	newMapStore := func(_descriptor string) (Store, error) {
 		return mapStore{
+			Mu:       &sync.Mutex{},
 			Contents: make(map[UserID]User),
+			APIKeys:  make(map[KeyID]APIKey),
 		}, nil
 	}
 	RegisterStorageMethod("", newMapStore)
@@ -144,6 +146,7 @@ func init() {
 
 	newFSStore := func(fileName string) (Store, error) {
 		rv := mapStore{
+			Mu:       &sync.Mutex{},
 			Contents: make(map[UserID]User),
 			APIKeys:  make(map[KeyID]APIKey),
 			Filename: fileName,
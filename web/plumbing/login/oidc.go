@@ -270,11 +270,12 @@ func (o OIDC) Callback() http.Handler {
 		}
 
 		var claims struct {
-			UserID        string `json:"sub,omitEmpty"`
-			Email         string `json:"email,omitEmpty"`
-			EmailVerified bool   `json:"email_verified,omitEmpty"`
-			FullName      string `json:"name,omitEmpty"`
-			GivenName     string `json:"given_name,omitEmpty"`
+			UserID        string   `json:"sub,omitEmpty"`
+			Email         string   `json:"email,omitEmpty"`
+			EmailVerified bool     `json:"email_verified,omitEmpty"`
+			FullName      string   `json:"name,omitEmpty"`
+			GivenName     string   `json:"given_name,omitEmpty"`
+			Groups        []string `json:"groups,omitEmpty"`
 		}
 		if err := idToken.Claims(&claims); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -297,6 +298,7 @@ func (o OIDC) Callback() http.Handler {
 
 		user.FullName = claims.FullName
 		user.GivenName = claims.GivenName
+		user.Groups = claims.Groups
 
 		err = o.store.StoreUser(ctx, user)
 		if err != nil {
@@ -12,7 +12,7 @@ import (
 
 type mapStore struct {
 	MaxDuration time.Duration
-	Mu          sync.Mutex
+	Mu          *sync.Mutex
 	Contents    map[SessionID]Session
 	Filename    string
 }
@@ -115,6 +115,7 @@ func init() {
 	newMapStore := func(_descriptor string) (Store, error) {
 		return mapStore{
 			MaxDuration: 72 * time.Hour,
+			Mu:          &sync.Mutex{},
 			Contents:    make(map[SessionID]Session),
 		}, nil
 	}
@@ -125,6 +126,7 @@ func init() {
 	newFSStore := func(fileName string) (Store, error) {
 		rv := mapStore{
 			MaxDuration: 72 * time.Hour,
+			Mu:          &sync.Mutex{},
 			Contents:    make(map[SessionID]Session),
 			Filename:    fileName,
 		}
@@ -20,7 +20,7 @@ func NewSessionID() SessionID {
 
 type Session struct {
 	ID        SessionID
-	Dirty     bool `json:"-",xml:"-"`
+	Dirty     bool `json:"-" xml:"-"`
 	Started   time.Time
 	LastSeen  time.Time
 	Destroyed bool `json:"destroyed,omitEmpty"`